@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+// Stub on-disk size implementation for non-Linux systems.
+// This file is excluded on Linux, where the allocated blocks are reported natively.
+
+package timberjack
+
+import (
+	"os"
+)
+
+var onDiskSize = func(info os.FileInfo) int64 {
+	return info.Size()
+}
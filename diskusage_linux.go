@@ -0,0 +1,16 @@
+package timberjack
+
+import (
+	"os"
+	"syscall"
+)
+
+// onDiskSize returns the space allocated for a file, which differs from its
+// logical size for sparse, preallocated or transparently compressed files.
+var onDiskSize = func(info os.FileInfo) int64 {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.Size()
+	}
+	return stat.Blocks * 512 // st_blocks is always in 512-byte units
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+// Stub free space implementation for non-Linux systems.
+// This file is excluded on Linux, where the free space is queried natively.
+
+package timberjack
+
+import (
+	"errors"
+)
+
+var freeBytes = func(_ string) (int64, error) {
+	return 0, errors.New("free space unknown on this platform")
+}
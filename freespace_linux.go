@@ -0,0 +1,15 @@
+package timberjack
+
+import (
+	"syscall"
+)
+
+// freeBytes returns the space available to unprivileged users on the
+// filesystem holding dir.
+var freeBytes = func(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
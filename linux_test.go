@@ -4,8 +4,12 @@
 package timberjack
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"testing"
 	"time"
@@ -82,8 +86,9 @@ func TestMaintainOwner(t *testing.T) {
 	err = l.Rotate()
 	isNil(err, t)
 
-	equals(555, fakeFS.files[filename].uid, t)
-	equals(666, fakeFS.files[filename].gid, t)
+	owner := fakeFS.owner(filename)
+	equals(555, owner.uid, t)
+	equals(666, owner.gid, t)
 }
 
 func TestCompressMaintainMode(t *testing.T) {
@@ -116,9 +121,8 @@ func TestCompressMaintainMode(t *testing.T) {
 	err = l.Rotate()
 	isNil(err, t)
 
-	// we need to wait a little bit since the files get compressed on a different
-	// goroutine.
-	<-time.After(10 * time.Millisecond)
+	// The files get compressed on a different goroutine; Close waits for it.
+	isNil(l.Close(), t)
 
 	// a compressed version of the log file should now exist with the correct
 	// mode.
@@ -166,15 +170,15 @@ func TestCompressMaintainOwner(t *testing.T) {
 	err = l.Rotate()
 	isNil(err, t)
 
-	// we need to wait a little bit since the files get compressed on a different
-	// goroutine.
-	<-time.After(10 * time.Millisecond)
+	// The files get compressed on a different goroutine; Close waits for it.
+	isNil(l.Close(), t)
 
 	// a compressed version of the log file should now exist with the correct
 	// owner.
 	filename2 := backupFileWithReason(dir, "size")
-	equals(555, fakeFS.files[filename2+compressSuffix].uid, t)
-	equals(666, fakeFS.files[filename2+compressSuffix].gid, t)
+	owner := fakeFS.owner(filename2 + compressSuffix)
+	equals(555, owner.uid, t)
+	equals(666, owner.gid, t)
 }
 
 type fakeFile struct {
@@ -183,6 +187,7 @@ type fakeFile struct {
 }
 
 type fakeFS struct {
+	mu    sync.Mutex // Chown is called by both rotation and the mill goroutine
 	files map[string]fakeFile
 }
 
@@ -191,10 +196,18 @@ func newFakeFS() *fakeFS {
 }
 
 func (fs *fakeFS) Chown(name string, uid, gid int) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 	fs.files[name] = fakeFile{uid: uid, gid: gid}
 	return nil
 }
 
+func (fs *fakeFS) owner(name string) fakeFile {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.files[name]
+}
+
 func (fs *fakeFS) Stat(name string) (os.FileInfo, error) {
 	info, err := os.Stat(name)
 	if err != nil {
@@ -221,3 +234,205 @@ func TestChownInvalidSys(t *testing.T) {
 		t.Fatalf("expected chown to fail on invalid Sys(), got: %v", err)
 	}
 }
+
+func TestCompressStopsOnNoSpace(t *testing.T) {
+	dir := t.TempDir()
+
+	// Writes to /dev/full fail with ENOSPC, like a full disk.
+	var gzOpens int
+	origOpenFile := osOpenFile
+	osOpenFile = func(name string, flag int, perm os.FileMode) (*os.File, error) {
+		if strings.HasSuffix(name, compressSuffix) {
+			gzOpens++
+			return os.OpenFile("/dev/full", os.O_WRONLY, 0)
+		}
+		return origOpenFile(name, flag, perm)
+	}
+	defer func() { osOpenFile = origOpenFile }()
+
+	var reported []error
+	l := &Logger{
+		Filename:        logFile(dir),
+		Compress:        true,
+		OnRotationError: func(err error) { reported = append(reported, err) },
+	}
+
+	names := []string{
+		"foobar-2025-01-01T00-00-00.000-size.log",
+		"foobar-2025-01-02T00-00-00.000-size.log",
+	}
+	for _, name := range names {
+		isNil(os.WriteFile(filepath.Join(dir, name), []byte("some log data\n"), 0644), t)
+	}
+
+	isNil(l.millRunOnce(), t)
+
+	// One attempt, then compression stops for this run.
+	equals(1, gzOpens, t)
+	equals(1, len(reported), t)
+	assert(errors.Is(reported[0], syscall.ENOSPC), t, "expected ENOSPC, got %v", reported[0])
+
+	// The sources are preserved and no partial archives are left behind.
+	for _, name := range names {
+		existsWithContent(filepath.Join(dir, name), []byte("some log data\n"), t)
+		notExist(filepath.Join(dir, name+compressSuffix), t)
+	}
+	fileCount(dir, 2, t)
+}
+
+func TestRotationDoesNotLeakDescriptors(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := t.TempDir()
+
+	openFDs := func() int {
+		entries, err := os.ReadDir("/proc/self/fd")
+		isNil(err, t)
+		return len(entries)
+	}
+
+	// No retention settings, so no cleanup run lists the directory concurrently.
+	l := &Logger{Filename: logFile(dir), MaxSize: 10}
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+	before := openFDs()
+
+	for i := 0; i < 50; i++ {
+		_, err := l.Write([]byte("12345678"))
+		isNil(err, t)
+	}
+	equals(1, l.OpenFDCount(), t)
+	equals(before, openFDs(), t)
+
+	isNil(l.Close(), t)
+	equals(before-1, openFDs(), t)
+}
+
+func TestFileMode(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := t.TempDir()
+	filename := logFile(dir)
+
+	mode := os.FileMode(0640)
+	l := &Logger{Filename: filename, MaxSize: 10, FileMode: mode}
+	defer l.Close()
+
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+	info, err := os.Stat(filename)
+	isNil(err, t)
+	equals(mode, info.Mode(), t)
+
+	// A size rotation keeps the mode of the file it replaces.
+	newFakeTime()
+	_, err = l.Write([]byte("foooooo!"))
+	isNil(err, t)
+	existsWithContent(filename, []byte("foooooo!"), t)
+	info, err = os.Stat(filename)
+	isNil(err, t)
+	equals(mode, info.Mode(), t)
+	info, err = os.Stat(backupFileWithReason(dir, "size"))
+	isNil(err, t)
+	equals(mode, info.Mode(), t)
+}
+
+func TestFileModeDefault(t *testing.T) {
+	currentTime = fakeTime
+	filename := logFile(t.TempDir())
+	l := &Logger{Filename: filename}
+	defer l.Close()
+
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+	info, err := os.Stat(filename)
+	isNil(err, t)
+	equals(os.FileMode(0600), info.Mode(), t)
+}
+
+func TestNonRegularFilePassthrough(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := t.TempDir()
+	filename := logFile(dir)
+	isNil(syscall.Mkfifo(filename, 0600), t)
+
+	// Open the read end first so opening the write end doesn't block.
+	r, err := os.OpenFile(filename, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	isNil(err, t)
+	defer r.Close()
+
+	l := &Logger{Filename: filename, MaxSize: 10, NonRegularFilePassthrough: true}
+	defer l.Close()
+
+	// Far more than MaxSize, yet nothing is rotated.
+	for i := 0; i < 5; i++ {
+		n, err := l.Write([]byte("12345678"))
+		isNil(err, t)
+		equals(8, n, t)
+	}
+	isNil(l.Rotate(), t)
+
+	buf := make([]byte, 100)
+	n, err := r.Read(buf)
+	isNil(err, t)
+	equals(strings.Repeat("12345678", 5), string(buf[:n]), t)
+
+	fileCount(dir, 1, t)
+	info, err := os.Stat(filename)
+	isNil(err, t)
+	assert(info.Mode()&os.ModeNamedPipe != 0, t, "expected %s to still be a FIFO", filename)
+}
+
+func TestFreeBytes(t *testing.T) {
+	free, err := freeBytes(t.TempDir())
+	isNil(err, t)
+	assert(free > 0, t, "expected some free space, got %d", free)
+
+	_, err = freeBytes(filepath.Join(t.TempDir(), "missing"))
+	notNil(err, t)
+}
+
+func TestDropCacheOnRotate(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress=%v", compress), func(t *testing.T) {
+			currentTime = fakeTime
+			var mu sync.Mutex
+			var dropped []string
+			origDrop := dropPageCache
+			dropPageCache = func(path string) error {
+				mu.Lock()
+				defer mu.Unlock()
+				dropped = append(dropped, path)
+				return nil
+			}
+			defer func() { dropPageCache = origDrop }()
+
+			dir := t.TempDir()
+			l := &Logger{Filename: logFile(dir), Compress: compress, DropCacheOnRotate: true}
+			defer l.Close()
+			_, err := l.Write([]byte("boo!"))
+			isNil(err, t)
+			newFakeTime()
+			backup, err := l.RotateWithInfo()
+			isNil(err, t)
+
+			want := backup
+			if compress {
+				want += compressSuffix // the backup itself is gone by then
+			}
+			// Close waits for the mill, before dropPageCache is restored.
+			isNil(l.Close(), t)
+			mu.Lock()
+			defer mu.Unlock()
+			equals([]string{want}, dropped, t)
+		})
+	}
+}
+
+func TestDropPageCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "foobar.log")
+	isNil(os.WriteFile(path, []byte("boo!"), 0644), t)
+	isNil(dropPageCache(path), t)
+	existsWithContent(path, []byte("boo!"), t)
+}
@@ -0,0 +1,11 @@
+//go:build !linux || !(amd64 || arm64 || loong64 || mips64 || mips64le || ppc64 || ppc64le || riscv64 || s390x)
+// +build !linux !amd64,!arm64,!loong64,!mips64,!mips64le,!ppc64,!ppc64le,!riscv64,!s390x
+
+// Stub page cache implementation for other systems.
+// This file is excluded on 64-bit Linux, where the kernel is advised natively.
+
+package timberjack
+
+var dropPageCache = func(_ string) error {
+	return nil
+}
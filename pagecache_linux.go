@@ -0,0 +1,40 @@
+//go:build linux && (amd64 || arm64 || loong64 || mips64 || mips64le || ppc64 || ppc64le || riscv64 || s390x)
+// +build linux
+// +build amd64 arm64 loong64 mips64 mips64le ppc64 ppc64le riscv64 s390x
+
+package timberjack
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+const (
+	fadvDontNeed      = 4 // POSIX_FADV_DONTNEED
+	fadvDontNeedS390x = 6 // POSIX_FADV_DONTNEED on s390x
+)
+
+// dropPageCache advises the kernel that the cached pages of the file at path
+// are not needed anymore. Only clean pages are dropped, so the file is synced
+// first.
+var dropPageCache = func(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	advice := uintptr(fadvDontNeed)
+	if runtime.GOARCH == "s390x" {
+		advice = fadvDontNeedS390x
+	}
+	// On the 64-bit platforms fadvise64 takes (fd, offset, len, advice); a
+	// zero len covers the whole file.
+	if _, _, errno := syscall.Syscall6(syscall.SYS_FADVISE64, f.Fd(), 0, 0, advice, 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
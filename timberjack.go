@@ -19,17 +19,28 @@
 package timberjack
 
 import (
+	"bufio"
+	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"math"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 	"unicode"
 )
@@ -37,7 +48,10 @@ import (
 const (
 	backupTimeFormat = "2006-01-02T15-04-05.000"
 	compressSuffix   = ".gz"
+	metaSuffix       = ".meta"
+	indexSuffix      = ".idx"
 	defaultMaxSize   = 100
+	boundaryTailSize = 4096 // bytes of the current file passed to RotateBoundaryFunc
 )
 
 // ensure we always implement io.WriteCloser
@@ -55,6 +69,66 @@ func safeClose[T any](ch chan T) {
 	close(ch)
 }
 
+// Clock tells the time. Set Logger.Clock to control the time a Logger sees,
+// e.g. with a fake clock in tests.
+type Clock interface {
+	Now() time.Time
+}
+
+// ThinningPolicy configures how cleanup thins out older backups
+// (Logger.ThinningPolicy).
+type ThinningPolicy struct {
+	// KeepAllDays is the number of days back from now within which all backups
+	// are kept. Of older backups, only the newest of each calendar day (in UTC,
+	// or local time with LocalTime) is kept. Zero disables thinning. Like MaxAge,
+	// a day is 24 hours and the age is based on the timestamp in the filename.
+	KeepAllDays int `json:"keepalldays" yaml:"keepalldays"`
+}
+
+// Uploader ships backups elsewhere, e.g. to object storage. Set Logger.Uploader
+// to have every finished backup uploaded by the background cleanup.
+type Uploader interface {
+	// Upload copies the backup at localPath to its destination. It is called
+	// from the cleanup goroutine, one backup at a time, and must not call back
+	// into the Logger.
+	Upload(ctx context.Context, localPath string) error
+}
+
+// RotationReason identifies what triggered a rotation.
+type RotationReason int
+
+const (
+	// ReasonSize is a rotation because a write would have exceeded MaxSize.
+	ReasonSize RotationReason = iota + 1
+	// ReasonTime is a rotation because RotationInterval elapsed or a
+	// RotateAtMinutes mark was reached.
+	ReasonTime
+	// ReasonManual is a rotation requested through Rotate or RotateTo.
+	ReasonManual
+	// ReasonStartup is a rotation of a leftover log file caused by RotateOnStart.
+	ReasonStartup
+	// ReasonCount is a rotation because MaxWrites writes were made to the file.
+	ReasonCount
+)
+
+// String returns a lower-case name for r, e.g. "size".
+func (r RotationReason) String() string {
+	switch r {
+	case ReasonSize:
+		return "size"
+	case ReasonTime:
+		return "time"
+	case ReasonManual:
+		return "manual"
+	case ReasonStartup:
+		return "startup"
+	case ReasonCount:
+		return "count"
+	default:
+		return fmt.Sprintf("RotationReason(%d)", int(r))
+	}
+}
+
 // Logger is an io.WriteCloser that writes to the specified filename.
 //
 // Logger opens or creates the logfile on the first Write.
@@ -82,11 +156,27 @@ func safeClose[T any](ch chan T) {
 //
 // timberjack assumes only a single process is writing to the log files at a time.
 type Logger struct {
+	// stats holds the counters reported by Stats. It is kept as the first field so
+	// its 64-bit atomics stay aligned on 32-bit platforms.
+	stats loggerStats
+
 	// Filename is the file to write logs to.  Backup log files will be retained
 	// in the same directory.  It uses <processname>-timberjack.log in
 	// os.TempDir() if empty.
 	Filename string `json:"filename" yaml:"filename"`
 
+	// RequireFilename disables the default location for an empty Filename
+	// (<processname>-timberjack.log in os.TempDir()), which the OS may clean up at
+	// any time. With RequireFilename, New and Write return ErrFilenameRequired
+	// instead of silently writing there.
+	RequireFilename bool `json:"requirefilename" yaml:"requirefilename"`
+
+	// LazyCreate defers opening or creating the log file until the first write
+	// that contains something other than whitespace, so a Logger that never logs
+	// anything leaves no file behind. Whitespace written before then is held and
+	// written ahead of that first write, or dropped if the Logger is closed first.
+	LazyCreate bool `json:"lazycreate" yaml:"lazycreate"`
+
 	// MaxSize is the maximum size in megabytes of the log file before it gets
 	// rotated. It defaults to 100 megabytes.
 	MaxSize int `json:"maxsize" yaml:"maxsize"`
@@ -98,11 +188,31 @@ type Logger struct {
 	// based on age.
 	MaxAge int `json:"maxage" yaml:"maxage"`
 
+	// ThinningPolicy thins out older backups in cleanup, after MaxBackups and
+	// MaxAge have been applied. The default is not to thin out backups.
+	ThinningPolicy ThinningPolicy `json:"thinningpolicy" yaml:"thinningpolicy"`
+
 	// MaxBackups is the maximum number of old log files to retain.  The default
 	// is to retain all old log files (though MaxAge may still cause them to get
-	// deleted.) MaxBackups counts distinct rotation events (timestamps).
+	// deleted.) MaxBackups counts distinct rotation events (timestamps), so a
+	// backup that briefly exists both uncompressed and compressed counts once.
 	MaxBackups int `json:"maxbackups" yaml:"maxbackups"`
 
+	// MaxTotalSize, if greater than zero, caps the combined size in bytes of the
+	// backups on disk (compressed ones count with their compressed size). Cleanup
+	// applies it after MaxBackups and MaxAge, to the backups those leave, and
+	// removes the oldest backups until the rest fit, except that the newest
+	// backup is always kept, even if it exceeds the cap by itself. MaxFilesInDir
+	// is applied after it. The active file doesn't count.
+	MaxTotalSize int64 `json:"maxtotalsize" yaml:"maxtotalsize"`
+
+	// MinFreeBytes, if greater than zero, is the free space in bytes that must be
+	// left on the filesystem of the log file. While there is less, Write fails
+	// with ErrInsufficientDiskSpace before rotating or writing anything, so a full
+	// disk can't cost a backup. Free space is only known to timberjack on Linux;
+	// elsewhere this has no effect. It costs a statfs call on every Write.
+	MinFreeBytes int64 `json:"minfreebytes" yaml:"minfreebytes"`
+
 	// LocalTime determines if the time used for formatting the timestamps in
 	// backup files is the computer's local time.  The default is to use UTC
 	// time.
@@ -110,6 +220,9 @@ type Logger struct {
 
 	// Compress determines if the rotated log files should be compressed
 	// using gzip. The default is not to perform compression.
+	// If the disk fills up while compressing, the partial archive is removed, the
+	// error is passed to OnRotationError and the remaining backups are left
+	// uncompressed until the next cleanup run.
 	Compress bool `json:"compress" yaml:"compress"`
 
 	// RotationInterval is the maximum duration between log rotations.
@@ -120,6 +233,25 @@ type Logger struct {
 	// Example: RotationInterval = time.Hour * 24 will rotate logs daily.
 	RotationInterval time.Duration `json:"rotationinterval" yaml:"rotationinterval"`
 
+	// AlignInterval, if true, makes RotationInterval rotations happen on clock
+	// boundaries: at the multiples of RotationInterval counted from midnight (in
+	// UTC, or local time with LocalTime), e.g. at the top of every hour for an
+	// hourly interval, instead of one interval after the previous rotation. The
+	// first rotation happens at the first boundary after the logger starts.
+	// Intervals that don't divide 24 hours restart from each midnight.
+	AlignInterval bool `json:"aligninterval" yaml:"aligninterval"`
+
+	// RotationJitter, if greater than zero, delays each RotationInterval deadline by
+	// a random duration in [0, RotationJitter), so that many loggers started at the
+	// same moment don't all rotate at the same instant.
+	RotationJitter time.Duration `json:"rotationjitter" yaml:"rotationjitter"`
+
+	// JitterRand, if set, is the source of randomness for RotationJitter. Set it to
+	// e.g. rand.New(rand.NewSource(42)) to get a reproducible jitter sequence. It is
+	// only used while the Logger's lock is held. By default each Logger uses its own
+	// time-seeded source, so loggers don't contend on the global math/rand lock.
+	JitterRand *rand.Rand `json:"-" yaml:"-"`
+
 	// BackupTimeFormat defines the layout for the timestamp appended to rotated file names.
 	// While other formats are allowed, it is recommended to follow the standard Go time layout
 	// (https://pkg.go.dev/time#pkg-constants). Use the ValidateBackupTimeFormat() method to check
@@ -135,6 +267,18 @@ type Logger struct {
 	// where `rotationCriterion` could be `time` or `size`.
 	BackupTimeFormat string `json:"backuptimeformat" yaml:"backuptimeformat"`
 
+	// PreviousBackupTimeFormats are layouts BackupTimeFormat had before it was
+	// changed. Backups whose timestamp doesn't parse with the current format are
+	// parsed with each of these in turn, so backups from earlier runs are still
+	// recognized and cleaned up.
+	PreviousBackupTimeFormats []string `json:"previousbackuptimeformats" yaml:"previousbackuptimeformats"`
+
+	// OmitReasonInName names backups <name>-<timestamp>.<ext>, without the
+	// rotation reason segment, for consumers that expect a plain timestamp.
+	// The default (false) keeps the reason in the name. Backups are
+	// recognized for cleanup with or without the reason either way.
+	OmitReasonInName bool `json:"omitreasoninname" yaml:"omitreasoninname"`
+
 	// RotateAtMinutes defines specific minutes within an hour (0-59) to trigger a rotation.
 	// For example, []int{0} for top of the hour, []int{0, 30} for top and half-past the hour.
 	// Rotations are aligned to the clock minute (second 0).
@@ -142,27 +286,502 @@ type Logger struct {
 	// If multiple rotation conditions are met, the first one encountered typically triggers.
 	RotateAtMinutes []int `json:"rotateAtMinutes" yaml:"rotateAtMinutes"`
 
+	// CatchUpMissedRotations makes the scheduled rotation goroutine perform a single
+	// catch-up rotation when a RotateAtMinutes or RotateOnWeekdays mark was missed,
+	// e.g. because the process was suspended (laptop sleep, container pause) or
+	// restarted across the mark with PersistState. Without it, missed marks are
+	// skipped until the next one.
+	CatchUpMissedRotations bool `json:"catchupmissedrotations" yaml:"catchupmissedrotations"`
+
+	// RotateOnWeekdays, together with RotateAtTimeOfDay, schedules rotations on
+	// specific days of the week, e.g. []time.Weekday{time.Sunday} with
+	// RotateAtTimeOfDay = 2 * time.Hour rotates every Sunday at 02:00.
+	// Like RotateAtMinutes, these rotations are performed by the scheduled rotation
+	// goroutine and are evaluated in local time if LocalTime is true, UTC otherwise.
+	RotateOnWeekdays []time.Weekday `json:"rotateonweekdays" yaml:"rotateonweekdays"`
+
+	// RotateAtTimeOfDay is the time after midnight at which RotateOnWeekdays
+	// rotations happen. It must be less than 24h; the default is midnight.
+	RotateAtTimeOfDay time.Duration `json:"rotateattimeofday" yaml:"rotateattimeofday"`
+
+	// TriggerFile, if set, is the path of a sentinel file that requests a rotation.
+	// A background goroutine polls for the file and, when it appears, rotates the
+	// log and removes the trigger file. This gives tooling that cannot send signals
+	// a file-based way to force a rotation, e.g. `touch /var/log/foo/server.log.rotate`.
+	TriggerFile string `json:"triggerfile" yaml:"triggerfile"`
+
+	// MaxFileAge, if greater than zero, bounds how long a single log file stays
+	// active. A background goroutine rotates the current file (with reason "time")
+	// once it has been open for longer than MaxFileAge, even if nothing is being
+	// written, so a quiet log doesn't keep the same file forever. Empty files are
+	// left alone. The age of a file appended to on startup is counted from when
+	// the Logger first sees it.
+	MaxFileAge time.Duration `json:"maxfileage" yaml:"maxfileage"`
+
+	// MaxWrites, if greater than zero, rotates the current file (with reason
+	// "count") right after the Write that makes it the MaxWrites-th write to the
+	// file, regardless of its size. Writes are counted from the last rotation, or
+	// from when the Logger opened the file.
+	MaxWrites int `json:"maxwrites" yaml:"maxwrites"`
+
+	// RotationBufferSize, if greater than zero, is the number of bytes of writes that
+	// may be held in memory while a rotation is in progress. Instead of waiting for a
+	// (possibly slow) rotation to finish, such writes are buffered and return
+	// immediately; the buffer is flushed, in arrival order, into the new log file as
+	// soon as the rotation completes. Writes that don't fit in the remaining buffer
+	// space wait for the rotation as usual. The default (0) disables buffering.
+	RotationBufferSize int `json:"rotationbuffersize" yaml:"rotationbuffersize"`
+
+	// CompressBufferSize is the size in bytes of the buffer used to copy a backup
+	// into its compressed file. A larger buffer reduces the number of read syscalls
+	// for large backups on fast disks. The default (0) uses io.Copy's default of 32KB.
+	CompressBufferSize int `json:"compressbuffersize" yaml:"compressbuffersize"`
+
+	// CompressLevel is the gzip compression level, from gzip.BestSpeed (1) to
+	// gzip.BestCompression (9). The default (0) is gzip.DefaultCompression, which
+	// is also used, with a warning on stderr, if the level is out of range.
+	CompressLevel int `json:"compresslevel" yaml:"compresslevel"`
+
+	// FailOnAppendOpenError controls what happens when the existing log file can't
+	// be opened for appending, e.g. because of its permissions. By default the file
+	// is moved aside as a backup and a new log file is created. When set, the open
+	// error is returned from Write instead, so that misconfiguration surfaces.
+	FailOnAppendOpenError bool `json:"failonappendopenerror" yaml:"failonappendopenerror"`
+
+	// RotateBoundaryFunc, if set, is consulted before a size-based rotation with the
+	// last bytes (up to 4KB) of the current log file. If it returns false, the file
+	// is not at a safe boundary (e.g. in the middle of a JSON object written in
+	// several pieces) and the rotation is deferred to a later write, letting the
+	// file grow past MaxSize in the meantime. A function that never returns true
+	// therefore disables size-based rotation.
+	RotateBoundaryFunc func(lastBytes []byte) bool `json:"-" yaml:"-"`
+
+	// LineAware defers a size-based rotation while the log file doesn't end with
+	// a newline, i.e. while its last line has only been written in part, so that
+	// no backup ends in the middle of a line. The file may then grow past MaxSize
+	// until a write ends with a newline. It costs a read of the file's last byte
+	// whenever a size rotation is due. It can be combined with RotateBoundaryFunc.
+	LineAware bool `json:"lineaware" yaml:"lineaware"`
+
+	// SoftMaxSize makes MaxSize a target rather than a hard limit. By default, a
+	// write that would take the file over MaxSize goes into a new file. With
+	// SoftMaxSize, the write goes into the current file, which is rotated once it
+	// has reached MaxSize, so files may slightly exceed MaxSize but a write is never
+	// split across files. A single write larger than MaxSize is still rejected.
+	SoftMaxSize bool `json:"softmaxsize" yaml:"softmaxsize"`
+
+	// Transform, if set, is applied to the payload of every Write before it is
+	// written, e.g. to redact sensitive values. It may return a shorter or longer
+	// slice, or nil to drop the write; it must not modify p in place, which
+	// belongs to the caller. Size limits and rotation use the length of the
+	// transformed data, but to satisfy io.Writer, Write still returns len(p) on
+	// success (and 0 on failure). Transform runs after NormalizeNewlines and
+	// before the copy to SyslogWriter, and may be called concurrently.
+	Transform func(p []byte) []byte `json:"-" yaml:"-"`
+
+	// SyslogWriter, if set, receives a copy of every Write, e.g. a *syslog.Writer
+	// from log/syslog to forward the log to the system logger as well. Each Write
+	// is passed on as exactly one Write call, never split or merged with others,
+	// so a caller writing one line per Write gets one syslog message per line.
+	// The copy is made before the write to the file, whether or not that
+	// succeeds, and with NormalizeNewlines it is normalized too. SyslogWriter may
+	// be called concurrently by concurrent Writes. Its errors are reported to
+	// stderr and OnRotationError but don't fail the Write.
+	SyslogWriter io.Writer `json:"-" yaml:"-"`
+
+	// TrackLatency, if true, records how long file writes and rotations take, as
+	// histograms in Stats, to notice when the storage slows down.
+	TrackLatency bool `json:"tracklatency" yaml:"tracklatency"`
+
+	// Clock, if set, is the source of the current time for all of the Logger's
+	// time-based decisions and names: rotation intervals and schedules, backup
+	// timestamps, MaxAge and other age checks. It is also read by the Logger's
+	// goroutines, so it must be safe for concurrent use. By default the system
+	// clock is used.
+	Clock Clock `json:"-" yaml:"-"`
+
+	// OpenFlags are extra flags for opening the active log file, such as
+	// syscall.O_NOATIME or syscall.O_DIRECT, OR'd into the flags timberjack uses
+	// itself (os.O_WRONLY with os.O_APPEND or os.O_CREATE|os.O_TRUNC). Flags that
+	// conflict with these, os.O_RDWR and os.O_TRUNC, make Write fail with
+	// ErrInvalidOpenFlags. Note that os.O_RDONLY is 0 and thus has no effect.
+	OpenFlags int `json:"openflags" yaml:"openflags"`
+
+	// FileMode is the permission mode for log files that timberjack creates,
+	// subject to the process umask. A file created by a rotation still takes
+	// the mode of the file it replaces. By default a new log file is created
+	// with 0600, or 0644 when written to after Close.
+	FileMode os.FileMode `json:"filemode" yaml:"filemode"`
+
+	// NonRegularFilePassthrough, if true, makes a Filename that names an
+	// existing non-regular file, such as a named pipe or a device, a plain write
+	// target: it is opened for writing as is and never rotated, whether by size,
+	// time, trigger or Rotate.
+	NonRegularFilePassthrough bool `json:"nonregularfilepassthrough" yaml:"nonregularfilepassthrough"`
+
+	// MaxRotationsPerMinute, if greater than zero, is a safety rail against
+	// rotation storms, e.g. from a tiny MaxSize and heavy logging. Once that many
+	// rotations (of any kind) have happened within a minute, size rotations are
+	// suppressed and the file grows beyond MaxSize until the minute is over. The
+	// first suppression in each minute is reported to OnRotationError as
+	// ErrRotationRateLimited.
+	MaxRotationsPerMinute int `json:"maxrotationsperminute" yaml:"maxrotationsperminute"`
+
+	// SizeFromStat makes MaxSize apply to the space the log file takes up on disk
+	// instead of to the number of bytes written to it. The two differ on
+	// filesystems with transparent compression (btrfs, zfs) and for sparse or
+	// preallocated files. The on-disk size is only known to timberjack on Linux;
+	// elsewhere this has no effect. It costs an fstat call on every Write, and
+	// since filesystems usually compress and allocate on writeback, the on-disk
+	// size lags behind recent writes.
+	SizeFromStat bool `json:"sizefromstat" yaml:"sizefromstat"`
+
+	// ReconcileSizeOnWrite makes every Write take the size of the log file from
+	// the file itself rather than from the bytes timberjack has written to it, so
+	// that size rotation follows the file when something else truncates or
+	// appends to it; after a truncation, writing continues at the new end of the
+	// file. It costs an fstat call on every Write.
+	ReconcileSizeOnWrite bool `json:"reconcilesizeonwrite" yaml:"reconcilesizeonwrite"`
+
+	// RingSize, if greater than zero, replaces timestamped backups with a ring of
+	// RingSize fixed names, Filename + ".0" to Filename + ".<RingSize-1>". Each
+	// rotation moves the current file into the first unused slot or, once all
+	// are used, overwrites the oldest one. The ring bounds the number of backups
+	// by itself, so MaxBackups, MaxAge and Compress have no effect.
+	RingSize int `json:"ringsize" yaml:"ringsize"`
+
+	// PreserveModTime, if true, gives each compressed backup the modification time
+	// of the file it was compressed from, instead of the time of compression, for
+	// tools that go by mtime.
+	PreserveModTime bool `json:"preservemodtime" yaml:"preservemodtime"`
+
+	// CompressMinAge, if greater than zero, leaves backups uncompressed until their
+	// rotation timestamp is at least this old, giving readers and log shippers time
+	// to finish with the uncompressed file. A backup that is too recent is
+	// compressed by the first cleanup run (after a rotation, or Reprocess) once it
+	// is old enough.
+	CompressMinAge time.Duration `json:"compressminage" yaml:"compressminage"`
+
+	// CompressionByReason overrides Compress for backups rotated for particular
+	// reasons, keyed by the reason as it appears in backup names ("size", "time",
+	// the StartupRotationReason, ...). The supported formats are "gzip" and "none";
+	// other formats are reported to stderr, once, and Compress applies instead.
+	// For example, map[string]string{"size": "gzip", "time": "none"} compresses
+	// size rotations but leaves time rotations readable as they are.
+	CompressionByReason map[string]string `json:"compressionbyreason" yaml:"compressionbyreason"`
+
+	// RecognizedCompressedExts lists further suffixes of compressed backups, such
+	// as ".zst" or ".bz2", besides ".gz". Backups with these suffixes (e.g. left
+	// over from a different compression setup, or compressed by external tools)
+	// are counted and removed by cleanup like any other backup. timberjack only
+	// ever produces ".gz" itself.
+	RecognizedCompressedExts []string `json:"recognizedcompressedexts" yaml:"recognizedcompressedexts"`
+
+	// BuildLineIndex makes cleanup record, for every backup, the offsets at which
+	// its lines start, in a small index file next to it (backup name without
+	// ".gz", plus ".idx"). LineIndex returns the index, so tools can seek straight
+	// to a line of a large backup. Indexes are removed together with their backup.
+	BuildLineIndex bool `json:"buildlineindex" yaml:"buildlineindex"`
+
+	// MaxFilesInDir is a safety cap on the number of files the Logger manages in
+	// the log directory: the active file, its backups and, if enabled, the state
+	// file, metadata sidecars and line indexes. When cleanup finds more than that,
+	// it removes the oldest backups, along with their sidecars and indexes, until
+	// the cap is met, regardless of MaxBackups and MaxAge. The default (0) means
+	// no cap.
+	MaxFilesInDir int `json:"maxfilesindir" yaml:"maxfilesindir"`
+
+	// PersistState makes the rotation schedule survive restarts. When set, the start
+	// time of the current log file and the time of the last rotation are recorded in
+	// a small state file next to the log (Filename + ".state"). When an existing log
+	// file is reopened, e.g. after a restart, they are restored from it, so that
+	// RotationInterval keeps counting from the last rotation instead of from the
+	// restart.
+	PersistState bool `json:"persiststate" yaml:"persiststate"`
+
+	// WriteMetaSidecar makes every rotation write a JSON sidecar next to the backup
+	// (backup name without ".gz", plus ".meta") recording the rotation reason and
+	// time, the backup's size and SHA-256 checksum, and whether it has been
+	// compressed. This keeps that information available if the backups are moved
+	// or renamed. Sidecars are removed together with their backup during cleanup.
+	WriteMetaSidecar bool `json:"writemetasidecar" yaml:"writemetasidecar"`
+
+	// AuditFile, if set, is a file to which timberjack appends a JSON line for
+	// every rotation, and every removal and compression by cleanup, with the time,
+	// the event ("rotate", "remove" or "compress"), the file affected (the backup,
+	// for rotations), the rotation reason, and the outcome ("ok" or "error", with
+	// the error). It is never treated as a backup, even in the log directory.
+	AuditFile string `json:"auditfile" yaml:"auditfile"`
+
+	// AuditMaxSize, if greater than zero, is the size in megabytes beyond which
+	// AuditFile is renamed to AuditFile + ".1", replacing any previous one, and
+	// started afresh.
+	AuditMaxSize int `json:"auditmaxsize" yaml:"auditmaxsize"`
+
+	// OnCleanup, if set, is called at the end of every cleanup run (compression and
+	// removal of old log files) with the paths of the backups removed and compressed
+	// in that run, even if both lists are empty. It is convenient for producing one
+	// consolidated audit entry per cleanup run.
+	// It is called from a background goroutine and must not call back into the Logger.
+	OnCleanup func(removed, compressed []string) `json:"-" yaml:"-"`
+
+	// Uploader, if set, is given every backup once it is final, i.e. compressed
+	// if it is to be compressed, in the cleanup after a rotation. A failed upload
+	// is reported through OnRotationError and retried by the next cleanup. Which
+	// backups have been uploaded is only remembered for the life of the Logger,
+	// so after a restart the remaining ones are uploaded again.
+	Uploader Uploader `json:"-" yaml:"-"`
+
+	// RemoveAfterUpload removes each backup from disk once Uploader has uploaded
+	// it successfully.
+	RemoveAfterUpload bool `json:"removeafterupload" yaml:"removeafterupload"`
+
+	// SkipInitialMill skips the cleanup run (which scans the whole log directory)
+	// normally performed when the log file is first opened, deferring cleanup to the
+	// first rotation. This avoids startup latency in directories with many files.
+	SkipInitialMill bool `json:"skipinitialmill" yaml:"skipinitialmill"`
+
+	// RotateOnStart rotates a non-empty log file left over from a previous run when
+	// the Logger first opens it, so every process run starts with a fresh file.
+	RotateOnStart bool `json:"rotateonstart" yaml:"rotateonstart"`
+
+	// NormalizeNewlines makes Write convert "\r\n" line endings to "\n" before
+	// writing, so that log files use Unix line endings whatever the source. Sizes
+	// (MaxSize) are accounted on the normalized bytes. A "\r\n" split across two
+	// writes is not converted.
+	NormalizeNewlines bool `json:"normalizenewlines" yaml:"normalizenewlines"`
+
+	// StartupRotationReason is the reason used in the backup filename for rotations
+	// caused by RotateOnStart. It defaults to "start", which distinguishes
+	// process-start rotations from size- and time-based ones.
+	StartupRotationReason string `json:"startuprotationreason" yaml:"startuprotationreason"`
+
+	// ReopenCacheTTL applies to writes made after Close. By default each such write
+	// opens the log file, writes to it and closes it again, which is slow. If
+	// ReopenCacheTTL is greater than zero, the descriptor opened by a post-close
+	// write is kept and reused by further post-close writes until none has happened
+	// for ReopenCacheTTL. Calling Close again releases it immediately.
+	ReopenCacheTTL time.Duration `json:"reopencachettl" yaml:"reopencachettl"`
+
+	// MillMaxConsecutiveErrors is the number of consecutive failed cleanup runs
+	// (compression and removal of old log files) after which the cleanup goroutine
+	// gives up and stops, instead of failing again on every rotation, e.g. when the
+	// log directory has become permanently unreadable. When the budget is exhausted,
+	// OnRotationError is called with an error wrapping ErrMillStopped and
+	// Stats().MillStopped reports true. The default (0) never stops.
+	MillMaxConsecutiveErrors int `json:"millmaxconsecutiveerrors" yaml:"millmaxconsecutiveerrors"`
+
+	// MaxMillWorkPerRun, if greater than zero, caps the number of backups a single
+	// background cleanup run removes or compresses, oldest first, so that catching
+	// up on a large backlog (e.g. after an outage) doesn't hog the disk in one go.
+	// The rest is left to further runs, which are scheduled right away. It doesn't
+	// apply to ApplyRetention and Reprocess.
+	MaxMillWorkPerRun int `json:"maxmillworkperrun" yaml:"maxmillworkperrun"`
+
+	// OnRotationError, if set, is called with errors from background work that has
+	// no caller to return them to, such as failed cleanup runs, and with conditions
+	// worth alerting on, such as entering DirUnavailableBackoff.
+	// It is called from a background goroutine or with the Logger's lock held, and
+	// must not call back into the Logger.
+	OnRotationError func(err error) `json:"-" yaml:"-"`
+
+	// OnStateChange, if set, is called when the Logger becomes unhealthy, after
+	// UnhealthyAfterFailures consecutive failed rotations or cleanup runs, and
+	// again when it is healthy again after the next successful one. It is only
+	// called on these transitions, not for every failure; reason describes the
+	// last failure, or is empty on recovery. It may be called from a background
+	// goroutine or with the Logger's lock held, and must not call back into the
+	// Logger.
+	OnStateChange func(healthy bool, reason string) `json:"-" yaml:"-"`
+
+	// UnhealthyAfterFailures is the number of consecutive failures after which
+	// OnStateChange reports the Logger as unhealthy. The default (0) means 1.
+	UnhealthyAfterFailures int `json:"unhealthyafterfailures" yaml:"unhealthyafterfailures"`
+
+	// DirUnavailableBackoff, if greater than zero, protects an unavailable log
+	// directory (e.g. a network mount that is down) from being hammered: after
+	// the log file fails to open, or a write to it fails (the file is then closed
+	// and reopened once the backoff is over), further attempts are held off for
+	// DirUnavailableBackoff, doubling after each consecutive failure up to 64
+	// times that. Each failure is also passed to OnRotationError. Writes made
+	// while backing off are kept in memory up to BackoffBufferSize bytes and
+	// written once the file opens again; beyond that they fail with
+	// ErrDirUnavailable.
+	DirUnavailableBackoff time.Duration `json:"dirunavailablebackoff" yaml:"dirunavailablebackoff"`
+
+	// BackoffBufferSize is the number of bytes of writes kept in memory during
+	// DirUnavailableBackoff. The default (0) drops all of them.
+	BackoffBufferSize int `json:"backoffbuffersize" yaml:"backoffbuffersize"`
+
+	// BufferSize, if greater than zero, is the size in bytes of a buffer that writes
+	// to the current file go through, saving a system call per Write under heavy
+	// load. The buffer is flushed when it fills up, before every rotation, on
+	// Flush, Sync and Close, and after FlushInterval of inactivity, if set. Buffered bytes count
+	// towards MaxSize like written ones. Data still in the buffer is lost if the
+	// process crashes.
+	BufferSize int `json:"buffersize" yaml:"buffersize"`
+
+	// FlushInterval, if greater than zero, flushes the write buffer (BufferSize)
+	// once no Write has been made for this long, bounding how stale the file on
+	// disk can get when writes stop.
+	FlushInterval time.Duration `json:"flushinterval" yaml:"flushinterval"`
+
+	// SyncBackupBeforeHook, if true, fsyncs each backup right after the rotation
+	// that produced it and before OnRotate is called, so that a hook uploading or
+	// otherwise shipping the backup never sees data that isn't on disk yet. A
+	// failed sync is reported to stderr and OnRotationError; the hook still runs.
+	SyncBackupBeforeHook bool `json:"syncbackupbeforehook" yaml:"syncbackupbeforehook"`
+
+	// DropCacheOnRotate, if true, advises the kernel to drop the page cache of
+	// each backup, and of each file written by compression, so that backups that
+	// are no longer read don't crowd out other data on memory-constrained hosts.
+	// It is done in the background cleanup after a rotation, which first syncs
+	// the file since only clean pages can be dropped. It has an effect on 64-bit
+	// Linux only.
+	DropCacheOnRotate bool `json:"dropcacheonrotate" yaml:"dropcacheonrotate"`
+
+	// OnRotate, if set, is called after every successful rotation with what
+	// triggered it and the path the previous log file was moved to (empty if
+	// there was no previous file); the new active file is always Filename. The
+	// backup path is the uncompressed one, as compression happens later.
+	//
+	// OnRotate is called after the Logger's lock is released, so it may call back
+	// into the Logger, and one call at a time, strictly in rotation order, so the
+	// backups can be handed on (e.g. queued for upload) in order. It has returned
+	// by the time the Write or Rotate that caused the rotation returns: if OnRotate
+	// is still running for an earlier rotation, that Write or Rotate waits. The
+	// exception is a rotation caused from within OnRotate, which is passed to it
+	// once the call in progress returns; for the same reason, OnRotate must not
+	// wait for a rotation made by another goroutine. A panic in OnRotate is
+	// recovered and reported to stderr and OnRotationError.
+	OnRotate func(reason RotationReason, backupPath string) `json:"-" yaml:"-"`
+
+	// WebhookFunc, if set, is called with a RotationEvent for every rotation, e.g.
+	// to notify an HTTP endpoint. Calls are made asynchronously by a few
+	// background goroutines, so they may overlap and complete out of order, and
+	// never hold up Write. A call that returns an error is retried, with a doubling
+	// delay, up to WebhookMaxRetries times; if the last attempt fails too, the
+	// error is reported to stderr and OnRotationError. Events that arrive while
+	// too many calls are pending are dropped and reported the same way.
+	WebhookFunc func(event RotationEvent) error `json:"-" yaml:"-"`
+
+	// WebhookMaxRetries is the number of times a failed WebhookFunc call is
+	// retried. It defaults to 3.
+	WebhookMaxRetries int `json:"webhookmaxretries" yaml:"webhookmaxretries"`
+
 	// Internal fields
 	size             int64     // current size of the log file
 	file             *os.File  // current log file
 	lastRotationTime time.Time // records the last time a rotation happened (for interval/scheduled).
 	logStartTime     time.Time // start time of the current logging period (used for backup filename timestamp).
 
+	rotationJitter time.Duration // random extra delay for the current RotationInterval period
+	jitterRand     *rand.Rand    // default source for RotationJitter when JitterRand is nil
+
+	persistedState loggerState // last state written to the state file (PersistState)
+	startupChecked bool        // whether RotateOnStart has been considered yet
+
+	// For RingSize
+	ringNext       int  // index of the slot the next rotation moves the file into
+	ringPositioned bool // whether ringNext has been determined from the existing slots
+
+	registeredPath string // key in the OpenExclusive registry, if registered
+
+	writeTime time.Time // time given to WriteAtTime for the write in progress, if any
+
+	auditMu sync.Mutex // serializes appends to AuditFile
+
+	// For DropCacheOnRotate
+	dropCacheMu      sync.Mutex // guards dropCachePending
+	dropCachePending []string   // backups whose page cache is still to be dropped
+
+	// For Uploader
+	uploadMu sync.Mutex      // serializes upload passes
+	uploaded map[string]bool // backups uploaded and kept (without RemoveAfterUpload)
+
+	// For OnRotate
+	pendingRotations []rotateEvent // rotations made while l.mu is held, not yet queued
+	hookMu           sync.Mutex    // guards hookQueue, hookRunning and hookGoroutine
+	hookQueue        []rotateEvent // rotations waiting for OnRotate, oldest first
+	hookRunning      bool          // whether a goroutine is calling OnRotate
+	hookGoroutine    uint64        // id of the goroutine calling OnRotate, if hookRunning
+
+	// For Events
+	events chan RotationEvent // created by the first call to Events; guarded by mu
+
+	// For WebhookFunc
+	startWebhooksOnce sync.Once          // ensures the webhook goroutines are started only once
+	webhookCh         chan RotationEvent // events waiting for WebhookFunc; guarded by mu
+
+	// For OnStateChange
+	healthMu       sync.Mutex // guards healthFailures and unhealthy; taken by Write and the mill
+	healthFailures int        // consecutive failed rotations and cleanup runs
+	unhealthy      bool       // whether OnStateChange last reported unhealthy
+
+	// For Tail
+	tails map[*tailReader]struct{} // readers following the current file
+
+	// For MaxRotationsPerMinute
+	windowStart     time.Time // start of the current one-minute window
+	windowRotations int       // rotations in the current window
+	windowWarned    bool      // whether suppression was reported in the current window
+
+	// For DirUnavailableBackoff
+	backoffDelay time.Duration // current delay, doubled after each consecutive failure
+	backoffUntil time.Time     // no attempt to open the log file before this time
+	backoffBuf   []byte        // writes held during backoff (BackoffBufferSize)
+
+	passthrough bool   // the active file is not a regular file (NonRegularFilePassthrough)
+	writeCount  int    // writes to the current file, for MaxWrites
+	lazyPending []byte // whitespace held until the file is created (LazyCreate)
+
+	lastReason   RotationReason // what triggered the most recent rotation
+	lastReasonAt time.Time      // when the most recent rotation happened
+
+	// For writes after Close (ReopenCacheTTL)
+	closedFile      *os.File    // descriptor reused by post-close writes
+	closedFileTimer *time.Timer // closes closedFile once it has been idle for ReopenCacheTTL
+
 	mu sync.Mutex // ensures atomic writes and rotations
 
+	// For buffering writes during rotation (RotationBufferSize)
+	rotationBufMu sync.Mutex // guards rotating and rotationBuf; never held while waiting on mu
+	rotating      bool       // true while a rotation is in progress
+	rotationBuf   []byte     // writes received while rotating, in arrival order
+
 	// For mill goroutine (backups, compression cleanup)
-	millCh    chan bool // channel to signal the mill goroutine
-	startMill sync.Once // ensures mill goroutine is started only once
+	millCh    chan bool      // channel to signal the mill goroutine
+	startMill sync.Once      // ensures mill goroutine is started only once
+	millWg    sync.WaitGroup // waits for the mill goroutine to finish
+	millMu    sync.Mutex     // serializes cleanup passes: the mill goroutine, Reprocess and ApplyRetention
 
 	// For scheduled rotation goroutine (RotateAtMinutes)
 	startScheduledRotationOnce sync.Once      // ensures scheduled rotation goroutine is started only once
 	scheduledRotationQuitCh    chan struct{}  // channel to signal the scheduled rotation goroutine to stop
 	scheduledRotationWg        sync.WaitGroup // waits for the scheduled rotation goroutine to finish
 	processedRotateAtMinutes   []int          // internal storage for sorted and validated RotateAtMinutes
+	processedRotateOnWeekdays  [7]bool        // validated RotateOnWeekdays, indexed by time.Weekday
+	hasWeekdaySchedule         bool           // whether any valid RotateOnWeekdays entry is set
+
+	// For trigger file watcher goroutine (TriggerFile)
+	startTriggerWatcherOnce sync.Once     // ensures trigger watcher goroutine is started only once
+	triggerWatcherQuitCh    chan struct{} // channel to signal the trigger watcher goroutine to stop
+
+	// For the file age goroutine (MaxFileAge)
+	startFileAgeWatcherOnce sync.Once     // ensures file age goroutine is started only once
+	fileAgeWatcherQuitCh    chan struct{} // channel to signal the file age goroutine to stop
+
+	// For buffered writes (BufferSize, FlushInterval)
+	buf              *bufio.Writer // buffers writes to file; nil until the first buffered write
+	lastBufferedAt   time.Time     // time of the last buffered write
+	startFlusherOnce sync.Once     // ensures the flush goroutine is started only once
+	flusherQuitCh    chan struct{} // channel to signal the flush goroutine to stop
 
 	// isBackupTimeFormatValidated flag helps prevent repeated validation checks
 	// on supplied format through configuration
 	isBackupTimeFormatValidated bool
+	compressLevelWarnOnce       sync.Once // warns about an invalid CompressLevel only once
+	compressionFormatWarnOnce   sync.Once // warns about unsupported CompressionByReason formats only once
 	isClosed                    uint32
 }
 
@@ -182,8 +801,83 @@ var (
 
 	osRemove = os.Remove
 
+	// osReadDir exists so it can be mocked out by tests.
+	osReadDir = os.ReadDir
+
+	// osOpenFile exists so it can be mocked out by tests.
+	osOpenFile = os.OpenFile
+
+	// triggerPollInterval is how often TriggerFile is checked for. It is a
+	// variable so tests can speed it up.
+	triggerPollInterval = time.Second
+
+	// fileAgeCheckInterval is how often the current file is checked against
+	// MaxFileAge (or every MaxFileAge, if that is shorter). It is a variable so
+	// tests can speed it up.
+	fileAgeCheckInterval = time.Minute
+
+	// flushCheckInterval is how often the write buffer is checked for having been
+	// idle for FlushInterval (or every FlushInterval, if that is shorter). It is a
+	// variable so tests can speed it up.
+	flushCheckInterval = time.Second
+
+	// webhookRetryDelay is the delay before the first retry of a failed
+	// WebhookFunc call. It is a variable so tests can speed it up.
+	webhookRetryDelay = time.Second
+
+	// syncPath flushes a file to stable storage. It is a variable so tests can
+	// observe it.
+	syncPath = func(path string) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return f.Sync()
+	}
+
+	// latencyNow is the clock for TrackLatency. It is separate from currentTime
+	// so tests can simulate slow storage without moving the rotation clock.
+	latencyNow = time.Now
+
 	// empty BackupTimeFormatField
 	ErrEmptyBackupTimeFormatField = errors.New("empty backupformat field")
+
+	// ErrMillStopped is reported through OnRotationError when the cleanup goroutine
+	// stops after MillMaxConsecutiveErrors consecutive failures.
+	ErrMillStopped = errors.New("timberjack: cleanup stopped")
+
+	// ErrTailOverrun ends a Tail whose reader fell more than tailBufferLimit
+	// bytes behind the writes.
+	ErrTailOverrun = errors.New("timberjack: tail reader fell behind")
+
+	// ErrRotationRateLimited is reported through OnRotationError when size
+	// rotations are suppressed because of MaxRotationsPerMinute.
+	ErrRotationRateLimited = errors.New("timberjack: rotation rate limit reached")
+
+	// ErrFileInUse is returned by OpenExclusive if another open Logger in this
+	// process already manages the file.
+	ErrFileInUse = errors.New("timberjack: log file already managed by another Logger")
+
+	// ErrClosed is returned by methods such as Rotate, Sync and Tail once the
+	// Logger has been closed. Write keeps working after Close (see Write).
+	ErrClosed = errors.New("timberjack: logger closed")
+
+	// ErrInvalidOpenFlags is returned by Write if OpenFlags contains flags that
+	// conflict with those timberjack needs.
+	ErrInvalidOpenFlags = errors.New("timberjack: invalid OpenFlags")
+
+	// ErrFilenameRequired is returned by New and Write if RequireFilename is set
+	// but Filename is empty.
+	ErrFilenameRequired = errors.New("timberjack: Filename is required")
+
+	// ErrDirUnavailable is returned by Write for writes dropped because opening the
+	// log file is being held off (DirUnavailableBackoff).
+	ErrDirUnavailable = errors.New("timberjack: log directory unavailable, backing off")
+
+	// ErrInsufficientDiskSpace is returned by Write when the free space on the
+	// filesystem of the log file is below MinFreeBytes.
+	ErrInsufficientDiskSpace = errors.New("timberjack: insufficient disk space")
 )
 
 // Write implements io.Writer.
@@ -193,21 +887,132 @@ var (
 // the file is closed, renamed to include a timestamp, and a new log file is created
 // using the original filename.
 // If the size of a single write exceeds MaxSize, the write is rejected and an error is returned.
+// A zero-length write is a no-op: it neither opens (or creates) the log file nor triggers a rotation.
+// With NormalizeNewlines, the returned count refers to bytes of p, not to the bytes written to the file.
 func (l *Logger) Write(p []byte) (n int, err error) {
+	return l.WriteAtTime(time.Time{}, p)
+}
+
+// WriteAtTime is like Write, but uses t instead of the current time for rotation
+// decisions and backup names, e.g. to import historical logs into the rotation
+// scheme with backups named after the time of their content. A zero t means the
+// current time.
+//
+// Timestamps should be non-decreasing: a t before the last rotation never
+// triggers a time-based rotation, and may produce backup names that sort before
+// existing ones. MaxAge is still measured against the current time, so backups
+// named for times older than MaxAge are removed by the next cleanup. Scheduled
+// rotations (RotateAtMinutes, MaxFileAge) keep following the wall clock.
+func (l *Logger) WriteAtTime(t time.Time, p []byte) (n int, err error) {
+	if l.Transform != nil {
+		return l.writeTransformed(t, p)
+	}
+	if l.NormalizeNewlines && bytes.Contains(p, crlf) {
+		n, err = l.write(t, bytes.ReplaceAll(p, crlf, lf), "")
+		return originalLen(p, n), err
+	}
+	return l.write(t, p, "")
+}
+
+// WriteMulti writes the concatenation of ps as a single Write: the slices end
+// up next to each other in the same file, never interleaved with other writes
+// or split by a rotation, and MaxSize applies to their total length. It returns
+// the number of bytes written in total.
+func (l *Logger) WriteMulti(ps ...[]byte) (n int, err error) {
+	if len(ps) == 1 {
+		return l.Write(ps[0])
+	}
+	return l.Write(bytes.Join(ps, nil))
+}
+
+// WriteString is like Write, but takes a string. Unless a feature needs the
+// data as bytes (e.g. Transform, SyslogWriter or Tail), it is written to the
+// file without first being copied into a []byte.
+func (l *Logger) WriteString(s string) (n int, err error) {
+	if l.Transform != nil || (l.NormalizeNewlines && strings.Contains(s, "\r\n")) {
+		return l.Write([]byte(s))
+	}
+	return l.write(time.Time{}, nil, s)
+}
+
+var (
+	crlf = []byte("\r\n")
+	lf   = []byte("\n")
+)
+
+// writeTransformed performs WriteAtTime with Transform (and NormalizeNewlines)
+// applied to p. As the written bytes can't be mapped back to those of p, it
+// reports either all of p or, on error, none of it as written.
+func (l *Logger) writeTransformed(t time.Time, p []byte) (int, error) {
+	q := p
+	if l.NormalizeNewlines && bytes.Contains(q, crlf) {
+		q = bytes.ReplaceAll(q, crlf, lf)
+	}
+	if _, err := l.write(t, l.Transform(q), ""); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// forwardToSyslog copies p to SyslogWriter in a single call. A failure is
+// reported but doesn't fail the write to the log file.
+func (l *Logger) forwardToSyslog(p []byte) {
+	if _, err := l.SyslogWriter.Write(p); err != nil {
+		err = fmt.Errorf("syslog write failed: %w", err)
+		fmt.Fprintf(os.Stderr, "timberjack: [%s] %v\n", l.Filename, err)
+		l.reportError(err)
+	}
+}
+
+// originalLen returns how many bytes of p produced the first n bytes of p with
+// every "\r\n" replaced by "\n".
+func originalLen(p []byte, n int) int {
+	i := 0
+	for out := 0; out < n && i < len(p); out++ {
+		if p[i] == '\r' && i+1 < len(p) && p[i+1] == '\n' {
+			i++
+		}
+		i++
+	}
+	return i
+}
+
+// write performs WriteAtTime after any newline normalization. It writes p, or
+// s if p is nil (WriteString).
+func (l *Logger) write(at time.Time, p []byte, s string) (n int, err error) {
+	if len(p)+len(s) == 0 {
+		return 0, nil
+	}
+	if p == nil && l.needsBytes() {
+		p, s = []byte(s), ""
+	}
+
+	if l.SyslogWriter != nil {
+		l.forwardToSyslog(p)
+	}
+
+	// Don't wait for an in-progress rotation if the write can be buffered instead.
+	if l.RotationBufferSize > 0 && l.bufferDuringRotation(p) {
+		return len(p), nil
+	}
+
 	l.mu.Lock()
-	defer l.mu.Unlock()
+	defer l.unlockAndNotify()
 
 	// Handle writes to a closed logger.
 	if atomic.LoadUint32(&l.isClosed) == 1 {
+		if l.ReopenCacheTTL > 0 {
+			return l.writeClosedCached(p)
+		}
 		// The logger is closed. To ensure the write succeeds, we perform a
 		// single open-write-close cycle. This does not perform rotation
 		// and does not restart the background goroutines. l.file remains nil.
-		file, openErr := os.OpenFile(l.filename(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		file, openErr := os.OpenFile(l.filename(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, l.fileMode(0644))
 		if openErr != nil {
 			return 0, fmt.Errorf("timberjack: write on closed logger failed to open file: %w", openErr)
 		}
 
-		n, writeErr := file.Write(p)
+		n, writeErr := writeTo(file, p, s)
 
 		closeErr := file.Close()
 
@@ -217,45 +1022,86 @@ func (l *Logger) Write(p []byte) (n int, err error) {
 		return n, closeErr
 	}
 
+	l.writeTime = at
+	defer func() { l.writeTime = time.Time{} }()
+
 	// Ensure the scheduled-rotation goroutine is running (if you've still got one).
 	l.ensureScheduledRotationLoopRunning()
+	l.ensureTriggerWatcherRunning()
+	l.ensureFileAgeWatcherRunning()
+	l.ensureFlusherRunning()
+
+	// Skip reading the clock entirely when only size-based rotation is configured.
+	timeTriggers := l.hasTimeTriggers()
 
-	// Anchor all checks to the same instant.
-	now := currentTime().In(l.location())
+	// Anchor all checks to the same instant. It is read only now that l.mu is
+	// held, so it can't predate a rotation that happened while we were waiting.
+	var now time.Time
+	if timeTriggers {
+		now = l.now().In(l.location())
+	}
 
-	writeLen := int64(len(p))
-	if writeLen > l.max() {
+	writeLen := int64(len(p) + len(s))
+	if writeLen > l.max() && !l.passthrough {
 		return 0, fmt.Errorf("write length %d exceeds maximum file size %d", writeLen, l.max())
 	}
 
 	// Open (or create) the file on first write.
 	if l.file == nil {
-		if err = l.openExistingOrNew(len(p)); err != nil {
+		if l.LazyCreate && len(bytes.TrimSpace(p)) == 0 {
+			l.lazyPending = append(l.lazyPending, p...)
+			return len(p), nil
+		}
+		if l.DirUnavailableBackoff > 0 && l.inBackoff() {
+			return l.holdDuringBackoff(p)
+		}
+		if err = l.openExistingOrNew(int(writeLen)); err != nil {
+			if l.DirUnavailableBackoff > 0 {
+				l.backOff(err)
+			}
 			return 0, err
 		}
-		if l.lastRotationTime.IsZero() {
+		if l.DirUnavailableBackoff > 0 {
+			l.endBackoff()
+		}
+		if len(l.lazyPending) > 0 {
+			held, errHeld := l.writeFile(l.lazyPending)
+			l.size += int64(held)
+			l.feedTails(l.lazyPending[:held])
+			l.lazyPending = nil
+			if errHeld != nil {
+				return 0, errHeld
+			}
+		}
+		if timeTriggers && l.lastRotationTime.IsZero() {
 			// Initialize to 'now' so interval/minute checks start from here.
 			l.lastRotationTime = now
+			l.rotationJitter = l.nextJitter()
 		}
 	}
 
-	// 1) Interval-based rotation
-	if l.RotationInterval > 0 && now.Sub(l.lastRotationTime) >= l.RotationInterval {
-		if err := l.rotate("time"); err != nil {
-			return 0, fmt.Errorf("interval rotation failed: %w", err)
-		}
-		l.lastRotationTime = now
+	if l.ReconcileSizeOnWrite {
+		l.reconcileSize()
 	}
 
-	// 2) Scheduled-minute rotation (RotateAtMinutes)
-	if len(l.processedRotateAtMinutes) > 0 {
+	if timeTriggers {
+		// 1) Interval-based rotation
+		if l.RotationInterval > 0 && l.intervalElapsed(now) {
+			if err := l.rotate(ReasonTime); err != nil {
+				return 0, fmt.Errorf("interval rotation failed: %w", err)
+			}
+			l.lastRotationTime = now
+			l.rotationJitter = l.nextJitter()
+		}
+
+		// 2) Scheduled-minute rotation (RotateAtMinutes)
 		for _, m := range l.processedRotateAtMinutes {
 			// Build the exact minute-mark timestamp in the current hour.
 			mark := time.Date(now.Year(), now.Month(), now.Day(),
 				now.Hour(), m, 0, 0, l.location())
 			// If we've crossed that mark since the last rotation, fire one rotation.
 			if l.lastRotationTime.Before(mark) && (mark.Before(now) || mark.Equal(now)) {
-				if err := l.rotate("time"); err != nil {
+				if err := l.rotate(ReasonTime); err != nil {
 					return 0, fmt.Errorf("scheduled-minute rotation failed: %w", err)
 				}
 				// Record the logical mark—so we don’t rerun until next slot.
@@ -265,20 +1111,131 @@ func (l *Logger) Write(p []byte) (n int, err error) {
 		}
 	}
 
+	if l.MinFreeBytes > 0 {
+		if err := l.checkFreeSpace(); err != nil {
+			return 0, err
+		}
+	}
+
 	// 3) Size-based rotation
-	if l.size+writeLen > l.max() {
-		if err := l.rotate("size"); err != nil {
+	if !l.SoftMaxSize && l.currentSize()+writeLen > l.max() && l.atRotationBoundary() && l.allowSizeRotation() {
+		if err := l.rotate(ReasonSize); err != nil {
 			return 0, fmt.Errorf("size rotation failed: %w", err)
 		}
+		// Writes buffered during the rotation (RotationBufferSize) went into the
+		// new file first. If p no longer fits after them, it gets a file of its own.
+		if l.RotationBufferSize > 0 && l.currentSize()+writeLen > l.max() && l.atRotationBoundary() && l.allowSizeRotation() {
+			if err := l.rotate(ReasonSize); err != nil {
+				return 0, fmt.Errorf("size rotation failed: %w", err)
+			}
+		}
 		// Note: we leave lastRotationTime untouched for size rotations.
 	}
 
+	l.persistState()
+
 	// Finally, write the bytes and update size.
-	n, err = l.file.Write(p)
+	if l.TrackLatency {
+		start := latencyNow()
+		n, err = l.writeData(p, s)
+		l.stats.writeLatency.observe(latencyNow().Sub(start))
+	} else {
+		n, err = l.writeData(p, s)
+	}
 	l.size += int64(n)
+	if len(l.tails) > 0 {
+		if p == nil {
+			p = []byte(s)
+		}
+		l.feedTails(p[:n])
+	}
+	if err != nil && l.DirUnavailableBackoff > 0 {
+		// The descriptor may be stale, e.g. on a remounted network share, so it
+		// is reopened after the backoff rather than written to again.
+		_ = l.closeFile()
+		l.backOff(err)
+		return n, err
+	}
+
+	if l.MaxWrites > 0 && err == nil {
+		l.writeCount++
+		if l.writeCount >= l.MaxWrites {
+			if errRotate := l.rotate(ReasonCount); errRotate != nil {
+				// The write itself succeeded; the next one retries the rotation.
+				errRotate = fmt.Errorf("count rotation failed: %w", errRotate)
+				fmt.Fprintf(os.Stderr, "timberjack: [%s] %v\n", l.Filename, errRotate)
+				l.reportError(errRotate)
+			}
+			return n, err
+		}
+	}
+
+	// With SoftMaxSize, rotate once the write has taken the file to MaxSize.
+	if l.SoftMaxSize && err == nil && l.currentSize() >= l.max() && l.atRotationBoundary() && l.allowSizeRotation() {
+		if errRotate := l.rotate(ReasonSize); errRotate != nil {
+			// The write itself succeeded; the next one retries the rotation.
+			errRotate = fmt.Errorf("size rotation failed: %w", errRotate)
+			fmt.Fprintf(os.Stderr, "timberjack: [%s] %v\n", l.Filename, errRotate)
+			l.reportError(errRotate)
+		}
+	}
 	return n, err
 }
 
+// needsBytes reports whether a write must be given as bytes because a feature
+// that sees the data before the file does (SyslogWriter, RotationBufferSize,
+// DirUnavailableBackoff, LazyCreate or ReopenCacheTTL) is configured.
+func (l *Logger) needsBytes() bool {
+	return l.SyslogWriter != nil || l.RotationBufferSize > 0 || l.DirUnavailableBackoff > 0 ||
+		l.LazyCreate || l.ReopenCacheTTL > 0
+}
+
+// writeTo writes p, or s if p is nil, to w.
+func writeTo(w io.Writer, p []byte, s string) (int, error) {
+	if p == nil {
+		return io.WriteString(w, s)
+	}
+	return w.Write(p)
+}
+
+// hasTimeTriggers reports whether any time-based rotation (RotationInterval or
+// valid RotateAtMinutes or RotateOnWeekdays) is configured. It expects l.mu to
+// be held.
+func (l *Logger) hasTimeTriggers() bool {
+	return l.RotationInterval > 0 || len(l.processedRotateAtMinutes) > 0 || l.hasWeekdaySchedule
+}
+
+// EffectiveBackupTimeFormat returns the time format used in backup filenames:
+// BackupTimeFormat if it is valid, or the default format otherwise. Like the
+// first rotation, it validates BackupTimeFormat (and falls back to the default)
+// if that hasn't happened yet.
+func (l *Logger) EffectiveBackupTimeFormat() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.validateBackupTimeFormatOnce()
+	return l.BackupTimeFormat
+}
+
+// validateBackupTimeFormatOnce replaces an empty or invalid BackupTimeFormat with
+// the default format, the first time it is called. It expects l.mu to be held.
+func (l *Logger) validateBackupTimeFormatOnce() {
+	if l.isBackupTimeFormatValidated {
+		return
+	}
+	// a backup format has been supplied.
+	validationErr := l.ValidateBackupTimeFormat()
+	if validationErr != nil {
+		// some validation issue.
+		// backup format is empty or invalid.
+		// use backupformat constant
+		l.BackupTimeFormat = backupTimeFormat
+		fmt.Fprintf(os.Stderr, "timberjack: invalid BackupTimeFormat: %v — falling back to default format: %s\n", validationErr, backupTimeFormat)
+	}
+	// mark the backup format as validated if there was no error.
+	// this would prevent validation checks in every rotation
+	l.isBackupTimeFormatValidated = true
+}
+
 // ValidateBackupTimeFormat checks if the configured BackupTimeFormat is a valid time layout.
 // While other formats are allowed, it is recommended to follow the standard time layout
 // rules as defined here: https://pkg.go.dev/time#pkg-constants
@@ -291,7 +1248,9 @@ func (l *Logger) ValidateBackupTimeFormat() error {
 	// 2025-05-22 23:41:59.987654321 +0000 UTC
 	now := time.Date(2025, 05, 22, 23, 41, 59, 987_654_321, time.UTC)
 
-	layoutPrecision := countDigitsAfterDot(l.BackupTimeFormat)
+	// Only the layout's fractional-seconds element, if any, determines the
+	// precision, not other digits that happen to follow a dot (e.g. "2006.01.02").
+	layoutPrecision := countDigitsAfterDot(strings.Replace(fractionalSecondsElement(l.BackupTimeFormat), ",", ".", 1))
 
 	now, err := truncateFractional(now, layoutPrecision)
 
@@ -321,7 +1280,7 @@ func (l *Logger) location() *time.Location {
 // ensureScheduledRotationLoopRunning starts the scheduled rotation goroutine if RotateAtMinutes is configured
 // and the goroutine is not already running.
 func (l *Logger) ensureScheduledRotationLoopRunning() {
-	if len(l.RotateAtMinutes) == 0 {
+	if len(l.RotateAtMinutes) == 0 && len(l.RotateOnWeekdays) == 0 {
 		return // No scheduled rotations configured
 	}
 
@@ -334,12 +1293,20 @@ func (l *Logger) ensureScheduledRotationLoopRunning() {
 				seenMinutes[m] = true
 			}
 		}
-		if len(l.processedRotateAtMinutes) == 0 {
+		sort.Ints(l.processedRotateAtMinutes) // Sort for predictable order in calculating next rotation
+		if l.RotateAtTimeOfDay >= 0 && l.RotateAtTimeOfDay < 24*time.Hour {
+			for _, d := range l.RotateOnWeekdays {
+				if d >= time.Sunday && d <= time.Saturday {
+					l.processedRotateOnWeekdays[d] = true
+					l.hasWeekdaySchedule = true
+				}
+			}
+		}
+		if len(l.processedRotateAtMinutes) == 0 && !l.hasWeekdaySchedule {
 			// Optionally log that no valid minutes were found, preventing goroutine start
 			// fmt.Fprintf(os.Stderr, "timberjack: [%s] No valid minutes specified for RotateAtMinutes.\n", l.Filename)
 			return
 		}
-		sort.Ints(l.processedRotateAtMinutes) // Sort for predictable order in calculating next rotation
 
 		l.scheduledRotationQuitCh = make(chan struct{})
 		l.scheduledRotationWg.Add(1)
@@ -353,7 +1320,7 @@ func (l *Logger) runScheduledRotations() {
 	defer l.scheduledRotationWg.Done()
 
 	// This check is redundant if ensureScheduledRotationLoopRunning already validated, but good for safety.
-	if len(l.processedRotateAtMinutes) == 0 {
+	if len(l.processedRotateAtMinutes) == 0 && !l.hasWeekdaySchedule {
 		return
 	}
 
@@ -367,30 +1334,14 @@ func (l *Logger) runScheduledRotations() {
 	}
 
 	for {
-		now := currentTime() // Use the mockable currentTime for testability
-		nowInLocation := now.In(l.location())
-		nextRotationAbsoluteTime := time.Time{}
-		foundNextSlot := false
-
-	determineNextSlot:
-		// Calculate the next rotation time based on the current time and processedRotateAtMinutes.
-		// Iterate through the current hour, then subsequent hours (up to 24h ahead for robustness
-		// against system sleep or large clock jumps).
-		for hourOffset := 0; hourOffset <= 24; hourOffset++ {
-			// Base time for the hour we are checking (e.g., if now is 10:35, current hour base is 10:00)
-			hourToCheck := time.Date(nowInLocation.Year(), nowInLocation.Month(), nowInLocation.Day(), nowInLocation.Hour(), 0, 0, 0, l.location()).Add(time.Duration(hourOffset) * time.Hour)
-
-			for _, minuteMark := range l.processedRotateAtMinutes { // l.processedRotateAtMinutes is sorted
-				candidateTime := time.Date(hourToCheck.Year(), hourToCheck.Month(), hourToCheck.Day(), hourToCheck.Hour(), minuteMark, 0, 0, l.location())
-
-				if candidateTime.After(now) { // Found the earliest future slot
-					nextRotationAbsoluteTime = candidateTime
-					foundNextSlot = true
-					break determineNextSlot // Exit both loops
-				}
-			}
+		if l.CatchUpMissedRotations {
+			l.catchUpMissedRotation()
 		}
 
+		now := l.clockNow() // Clock, or the mockable currentTime
+		nowInLocation := now.In(l.location())
+		nextRotationAbsoluteTime, foundNextSlot := l.nextScheduledRotation(now)
+
 		if !foundNextSlot {
 			// This should ideally not happen if processedRotateAtMinutes is valid and non-empty.
 			// Could occur if currentTime() is unreliable or jumps massively backward.
@@ -410,17 +1361,8 @@ func (l *Logger) runScheduledRotations() {
 		select {
 		case <-timer.C: // Timer fired, it's time for a scheduled rotation
 			l.mu.Lock()
-			// Only rotate if the last rotation time was before this specific scheduled mark.
-			// This prevents redundant rotations if another rotation (e.g., size/interval) happened
-			// very close to, but just before or at, this scheduled time for the same mark.
-			if l.lastRotationTime.Before(nextRotationAbsoluteTime) {
-				if err := l.rotate("time"); err != nil { // Scheduled rotations are "time" based for filename
-					fmt.Fprintf(os.Stderr, "timberjack: [%s] scheduled rotation failed: %v\n", l.Filename, err)
-				} else {
-					l.lastRotationTime = currentTime() // Update lastRotationTime after successful scheduled rotation
-				}
-			}
-			l.mu.Unlock()
+			l.scheduledRotation(nextRotationAbsoluteTime)
+			l.unlockAndNotify()
 			// Loop will continue and recalculate the next slot from the new "now"
 
 		case <-l.scheduledRotationQuitCh: // Signal to quit from Close()
@@ -437,146 +1379,1522 @@ func (l *Logger) runScheduledRotations() {
 	}
 }
 
-// Close implements io.Closer, and closes the current logfile.
-// It also signals any running goroutines (like scheduled rotation or mill) to stop.
-func (l *Logger) Close() error {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
+// scheduledRotation performs the rotation for a scheduled mark that has been
+// reached. It expects l.mu to be held.
+func (l *Logger) scheduledRotation(mark time.Time) {
 	if atomic.LoadUint32(&l.isClosed) == 1 {
-		return nil // Already closed
+		return // Close is waiting for the scheduled rotation goroutine to stop.
+	}
+	// Only rotate if the last rotation time was before this specific scheduled mark.
+	// This prevents redundant rotations if another rotation (e.g., size/interval) happened
+	// very close to, but just before or at, this scheduled time for the same mark.
+	if !l.lastRotationTime.Before(mark) {
+		return
+	}
+	if err := l.rotate(ReasonTime); err != nil { // Scheduled rotations are "time" based for filename
+		fmt.Fprintf(os.Stderr, "timberjack: [%s] scheduled rotation failed: %v\n", l.Filename, err)
+		return
 	}
+	// Update lastRotationTime after successful scheduled rotation. A Write that was
+	// waiting for l.mu meanwhile reads the clock only once it holds the lock, so it
+	// sees this rotation and doesn't rotate again for the same mark.
+	l.lastRotationTime = l.clockNow()
+	l.persistState()
+}
 
-	atomic.StoreUint32(&l.isClosed, 1)
+// scheduleLookahead bounds the search for the next scheduled rotation. Every
+// schedule that can be configured fires at least once a week, so a valid one is
+// always found well within it.
+const scheduleLookahead = 8 * 24 * time.Hour
 
-	// Stop and wait for the scheduled rotation goroutine
-	if l.scheduledRotationQuitCh != nil {
-		safeClose(l.scheduledRotationQuitCh)
-		l.scheduledRotationWg.Wait() // Wait for the goroutine to finish
-		l.scheduledRotationQuitCh = nil
+// nextScheduledRotation returns the earliest RotateAtMinutes or RotateOnWeekdays
+// slot after now, searching at most scheduleLookahead ahead.
+func (l *Logger) nextScheduledRotation(now time.Time) (time.Time, bool) {
+	next, found := l.nextMinuteSlot(now)
+	if weekdaySlot, ok := l.nextWeekdaySlot(now); ok && (!found || weekdaySlot.Before(next)) {
+		next, found = weekdaySlot, true
 	}
-
-	// Stop the mill goroutine. Original timberjack closes millCh.
-	if l.millCh != nil {
-		safeClose(l.millCh)
-		l.millCh = nil
+	if !found || next.Sub(now) > scheduleLookahead {
+		return time.Time{}, false
 	}
-
-	return l.closeFile() // Call the internal method to close the file descriptor
+	return next, true
 }
 
-// closeFile closes the file if it is open. This is an internal method.
-// It expects l.mu to be held.
-func (l *Logger) closeFile() error {
-	if l.file == nil {
-		return nil
+// nextMinuteSlot returns the first RotateAtMinutes mark after now.
+func (l *Logger) nextMinuteSlot(now time.Time) (time.Time, bool) {
+	if len(l.processedRotateAtMinutes) == 0 {
+		return time.Time{}, false
 	}
-	err := l.file.Close()
-	l.file = nil // Set to nil to indicate it's closed.
-	return err
+	nowInLocation := now.In(l.location())
+	// Base time for the current hour (e.g., if now is 10:35, it is 10:00). Marks
+	// recur every hour, but stepping through a few more hours copes with clock
+	// changes that repeat or skip one.
+	hourBase := time.Date(nowInLocation.Year(), nowInLocation.Month(), nowInLocation.Day(), nowInLocation.Hour(), 0, 0, 0, l.location())
+	for hourOffset := 0; hourOffset <= 3; hourOffset++ {
+		hourToCheck := hourBase.Add(time.Duration(hourOffset) * time.Hour).In(l.location())
+		for _, minuteMark := range l.processedRotateAtMinutes { // l.processedRotateAtMinutes is sorted
+			candidateTime := time.Date(hourToCheck.Year(), hourToCheck.Month(), hourToCheck.Day(), hourToCheck.Hour(), minuteMark, 0, 0, l.location())
+			if candidateTime.After(now) { // Found the earliest future slot
+				return candidateTime, true
+			}
+		}
+	}
+	return time.Time{}, false
 }
 
-// Rotate causes Logger to close the existing log file and immediately create a
-// new one. This is a helper function for applications that want to initiate
+// lastWeekdayMark returns the most recent RotateOnWeekdays/RotateAtTimeOfDay slot
+// at or before now, looking back up to a week.
+func (l *Logger) lastWeekdayMark(now time.Time) (time.Time, bool) {
+	if !l.hasWeekdaySchedule {
+		return time.Time{}, false
+	}
+	nowInLocation := now.In(l.location())
+	for dayOffset := 0; dayOffset <= 7; dayOffset++ {
+		day := time.Date(nowInLocation.Year(), nowInLocation.Month(), nowInLocation.Day()-dayOffset, 0, 0, 0, 0, l.location())
+		if !l.processedRotateOnWeekdays[day.Weekday()] {
+			continue
+		}
+		if candidate := day.Add(l.RotateAtTimeOfDay); !candidate.After(now) {
+			return candidate, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// nextWeekdaySlot returns the earliest RotateOnWeekdays/RotateAtTimeOfDay slot
+// strictly after now, searching up to a week ahead.
+func (l *Logger) nextWeekdaySlot(now time.Time) (time.Time, bool) {
+	if !l.hasWeekdaySchedule {
+		return time.Time{}, false
+	}
+	nowInLocation := now.In(l.location())
+	for dayOffset := 0; dayOffset <= 7; dayOffset++ {
+		day := time.Date(nowInLocation.Year(), nowInLocation.Month(), nowInLocation.Day()+dayOffset, 0, 0, 0, 0, l.location())
+		if !l.processedRotateOnWeekdays[day.Weekday()] {
+			continue
+		}
+		if candidate := day.Add(l.RotateAtTimeOfDay); candidate.After(now) {
+			return candidate, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ensureTriggerWatcherRunning starts the trigger file watcher goroutine if TriggerFile is configured
+// and the goroutine is not already running.
+func (l *Logger) ensureTriggerWatcherRunning() {
+	if l.TriggerFile == "" {
+		return
+	}
+
+	l.startTriggerWatcherOnce.Do(func() {
+		l.triggerWatcherQuitCh = make(chan struct{})
+		go l.runTriggerWatcher(triggerPollInterval, l.triggerWatcherQuitCh)
+	})
+}
+
+// runTriggerWatcher polls for TriggerFile and rotates the log whenever it appears,
+// consuming (removing) the trigger file afterwards. It runs in a separate goroutine
+// and exits once quit is closed.
+func (l *Logger) runTriggerWatcher(interval time.Duration, quit chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := osStat(l.TriggerFile); err != nil {
+				continue // No trigger present (or not accessible); check again on next tick.
+			}
+			if err := l.Rotate(); err != nil {
+				fmt.Fprintf(os.Stderr, "timberjack: [%s] trigger file rotation failed: %v\n", l.Filename, err)
+			}
+			// Consume the trigger even if rotation failed, so a persistent failure
+			// doesn't turn into a rotation attempt on every tick.
+			if err := osRemove(l.TriggerFile); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "timberjack: [%s] failed to remove trigger file %s: %v\n", l.Filename, l.TriggerFile, err)
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+// ensureFileAgeWatcherRunning starts the file age goroutine if MaxFileAge is configured
+// and the goroutine is not already running.
+func (l *Logger) ensureFileAgeWatcherRunning() {
+	if l.MaxFileAge <= 0 {
+		return
+	}
+
+	l.startFileAgeWatcherOnce.Do(func() {
+		interval := fileAgeCheckInterval
+		if l.MaxFileAge < interval {
+			interval = l.MaxFileAge
+		}
+		l.fileAgeWatcherQuitCh = make(chan struct{})
+		go l.runFileAgeWatcher(interval, l.fileAgeWatcherQuitCh)
+	})
+}
+
+// runFileAgeWatcher periodically rotates the current file once it is older than
+// MaxFileAge. It runs in a separate goroutine and exits once quit is closed.
+func (l *Logger) runFileAgeWatcher(interval time.Duration, quit chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.rotateIfTooOld()
+		case <-quit:
+			return
+		}
+	}
+}
+
+// rotateIfTooOld rotates the current file if it has been active for longer than MaxFileAge.
+func (l *Logger) rotateIfTooOld() {
+	l.mu.Lock()
+	defer l.unlockAndNotify()
+
+	if l.file == nil || atomic.LoadUint32(&l.isClosed) == 1 {
+		return
+	}
+	now := l.clockNow()
+	if l.logStartTime.IsZero() {
+		// Appended to an existing file; start counting from now.
+		l.logStartTime = now
+		return
+	}
+	if l.size == 0 || now.Sub(l.logStartTime) < l.MaxFileAge {
+		return
+	}
+	if err := l.rotate(ReasonTime); err != nil {
+		fmt.Fprintf(os.Stderr, "timberjack: [%s] file age rotation failed: %v\n", l.Filename, err)
+	}
+}
+
+// catchUpMissedRotation rotates once if the most recent RotateAtMinutes or
+// RotateOnWeekdays mark passed without a rotation since lastRotationTime.
+func (l *Logger) catchUpMissedRotation() {
+	l.mu.Lock()
+	defer l.unlockAndNotify()
+
+	if l.lastRotationTime.IsZero() || atomic.LoadUint32(&l.isClosed) == 1 {
+		return // Nothing written yet, or shutting down.
+	}
+	mark, ok := l.lastScheduledMark(l.clockNow())
+	if !ok || !l.lastRotationTime.Before(mark) {
+		return
+	}
+	if err := l.rotate(ReasonTime); err != nil {
+		fmt.Fprintf(os.Stderr, "timberjack: [%s] catch-up rotation failed: %v\n", l.Filename, err)
+		return
+	}
+	l.lastRotationTime = l.clockNow()
+	l.persistState()
+}
+
+// lastScheduledMark returns the most recent RotateAtMinutes or RotateOnWeekdays
+// mark at or before now.
+func (l *Logger) lastScheduledMark(now time.Time) (time.Time, bool) {
+	mark, found := l.lastMinuteMark(now)
+	if weekdayMark, ok := l.lastWeekdayMark(now); ok && (!found || weekdayMark.After(mark)) {
+		mark, found = weekdayMark, true
+	}
+	return mark, found
+}
+
+// lastMinuteMark returns the most recent RotateAtMinutes mark at or before now,
+// looking back up to 24 hours.
+func (l *Logger) lastMinuteMark(now time.Time) (time.Time, bool) {
+	nowInLocation := now.In(l.location())
+	for hourOffset := 0; hourOffset <= 24; hourOffset++ {
+		hourToCheck := time.Date(nowInLocation.Year(), nowInLocation.Month(), nowInLocation.Day(), nowInLocation.Hour(), 0, 0, 0, l.location()).Add(-time.Duration(hourOffset) * time.Hour)
+		for i := len(l.processedRotateAtMinutes) - 1; i >= 0; i-- { // Sorted ascending; walk backwards
+			candidate := time.Date(hourToCheck.Year(), hourToCheck.Month(), hourToCheck.Day(), hourToCheck.Hour(), l.processedRotateAtMinutes[i], 0, 0, l.location())
+			if !candidate.After(now) {
+				return candidate, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// Close implements io.Closer, and closes the current logfile.
+// It also signals any running goroutines (like scheduled rotation or mill) to stop.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if atomic.LoadUint32(&l.isClosed) == 1 {
+		return l.closeCachedFile() // Already closed; release any descriptor kept by post-close writes.
+	}
+
+	atomic.StoreUint32(&l.isClosed, 1)
+	l.unregister()
+
+	// Stop and wait for the scheduled rotation goroutine. It may be waiting for
+	// l.mu to rotate, so the lock is released meanwhile; once it gets the lock,
+	// it sees the logger is closed and leaves the file alone.
+	if l.scheduledRotationQuitCh != nil {
+		safeClose(l.scheduledRotationQuitCh)
+		l.mu.Unlock()
+		l.scheduledRotationWg.Wait() // Wait for the goroutine to finish
+		l.mu.Lock()
+		l.scheduledRotationQuitCh = nil
+	}
+
+	// Stop the trigger file watcher. It is not waited for: it may be blocked on
+	// l.mu inside Rotate, and will observe the closed logger once we release it.
+	if l.triggerWatcherQuitCh != nil {
+		safeClose(l.triggerWatcherQuitCh)
+		l.triggerWatcherQuitCh = nil
+	}
+
+	// Likewise for the file age goroutine.
+	if l.fileAgeWatcherQuitCh != nil {
+		safeClose(l.fileAgeWatcherQuitCh)
+		l.fileAgeWatcherQuitCh = nil
+	}
+
+	// And the idle flush goroutine. closeFile flushes what is left.
+	if l.flusherQuitCh != nil {
+		safeClose(l.flusherQuitCh)
+		l.flusherQuitCh = nil
+	}
+
+	// Stop and wait for the mill goroutine. Original timberjack closes millCh.
+	// A run in progress may take l.mu to signal itself again, so the lock is
+	// released meanwhile, as for the scheduled rotation goroutine.
+	if l.millCh != nil {
+		safeClose(l.millCh)
+		l.millCh = nil
+		l.mu.Unlock()
+		l.millWg.Wait()
+		l.mu.Lock()
+	}
+
+	l.endTails()
+	if l.events != nil {
+		close(l.events)
+	}
+	// Pending webhook calls are still made, but no new ones are queued.
+	if l.webhookCh != nil {
+		safeClose(l.webhookCh)
+		l.webhookCh = nil
+	}
+	return l.closeFile() // Call the internal method to close the file descriptor
+}
+
+// fileMode returns FileMode, or def if it is not set.
+func (l *Logger) fileMode(def os.FileMode) os.FileMode {
+	if l.FileMode != 0 {
+		return l.FileMode
+	}
+	return def
+}
+
+// writeClosedCached writes p on a closed logger through a descriptor that is
+// kept open for ReopenCacheTTL after the last such write.
+// It expects l.mu to be held.
+func (l *Logger) writeClosedCached(p []byte) (int, error) {
+	if l.closedFile == nil {
+		file, err := os.OpenFile(l.filename(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, l.fileMode(0644))
+		if err != nil {
+			return 0, fmt.Errorf("timberjack: write on closed logger failed to open file: %w", err)
+		}
+		l.closedFile = file
+		l.closedFileTimer = time.AfterFunc(l.ReopenCacheTTL, l.expireCachedFile)
+	} else {
+		l.closedFileTimer.Reset(l.ReopenCacheTTL)
+	}
+	return l.closedFile.Write(p)
+}
+
+// expireCachedFile is run by closedFileTimer to release the post-close descriptor.
+func (l *Logger) expireCachedFile() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_ = l.closeCachedFile()
+}
+
+// OpenFDCount returns the number of file descriptors the Logger currently holds
+// open: 1 while it has an active log file (or, after Close, a descriptor kept
+// for ReopenCacheTTL), 0 otherwise. It is meant for leak checks in tests and
+// health checks; rotations never leave more than one descriptor open.
+func (l *Logger) OpenFDCount() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	if l.file != nil {
+		n++
+	}
+	if l.closedFile != nil {
+		n++
+	}
+	return n
+}
+
+// closeCachedFile closes the descriptor kept for post-close writes, if any.
+// It expects l.mu to be held.
+func (l *Logger) closeCachedFile() error {
+	if l.closedFile == nil {
+		return nil
+	}
+	l.closedFileTimer.Stop()
+	err := l.closedFile.Close()
+	l.closedFile = nil
+	l.closedFileTimer = nil
+	return err
+}
+
+// closeFile closes the file if it is open. This is an internal method.
+// It expects l.mu to be held.
+func (l *Logger) closeFile() error {
+	if l.file == nil {
+		return nil
+	}
+	err := l.flushBuffer()
+	l.buf = nil
+	if errClose := l.file.Close(); err == nil {
+		err = errClose
+	}
+	l.file = nil // Set to nil to indicate it's closed.
+	return err
+}
+
+// writeFile writes p to the current file, through the write buffer if BufferSize
+// is set. It expects l.mu to be held and the file to be open.
+func (l *Logger) writeFile(p []byte) (int, error) {
+	if l.BufferSize <= 0 {
+		return l.file.Write(p)
+	}
+	if l.buf == nil {
+		l.buf = bufio.NewWriterSize(l.file, l.BufferSize)
+	}
+	l.lastBufferedAt = l.clockNow()
+	return l.buf.Write(p)
+}
+
+// writeData is writeFile for p, or s if p is nil. It expects l.mu to be held
+// and the file to be open.
+func (l *Logger) writeData(p []byte, s string) (int, error) {
+	if p != nil {
+		return l.writeFile(p)
+	}
+	if l.BufferSize <= 0 {
+		return l.file.WriteString(s)
+	}
+	if l.buf == nil {
+		l.buf = bufio.NewWriterSize(l.file, l.BufferSize)
+	}
+	l.lastBufferedAt = l.clockNow()
+	return l.buf.WriteString(s)
+}
+
+// flushBuffer writes out the buffered data, if any. It expects l.mu to be held.
+func (l *Logger) flushBuffer() error {
+	if l.buf == nil || l.buf.Buffered() == 0 {
+		return nil
+	}
+	return l.buf.Flush()
+}
+
+// ensureFlusherRunning starts the idle flush goroutine if BufferSize and
+// FlushInterval are configured and the goroutine is not already running.
+func (l *Logger) ensureFlusherRunning() {
+	if l.BufferSize <= 0 || l.FlushInterval <= 0 {
+		return
+	}
+
+	l.startFlusherOnce.Do(func() {
+		interval := flushCheckInterval
+		if l.FlushInterval < interval {
+			interval = l.FlushInterval
+		}
+		l.flusherQuitCh = make(chan struct{})
+		go l.runFlusher(interval, l.flusherQuitCh)
+	})
+}
+
+// runFlusher periodically flushes the write buffer once no write has been made
+// for FlushInterval. It runs in a separate goroutine and exits once quit is closed.
+func (l *Logger) runFlusher(interval time.Duration, quit chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.flushIfIdle()
+		case <-quit:
+			return
+		}
+	}
+}
+
+// flushIfIdle flushes the write buffer if the last write is FlushInterval old.
+func (l *Logger) flushIfIdle() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil || l.clockNow().Sub(l.lastBufferedAt) < l.FlushInterval {
+		return
+	}
+	if err := l.flushBuffer(); err != nil {
+		err = fmt.Errorf("idle flush failed: %w", err)
+		fmt.Fprintf(os.Stderr, "timberjack: [%s] %v\n", l.Filename, err)
+		l.reportError(err)
+	}
+}
+
+// Rotate causes Logger to close the existing log file and immediately create a
+// new one. This is a helper function for applications that want to initiate
 // rotations outside of the normal rotation rules, such as in response to
 // SIGHUP. After rotating, this initiates compression and removal of old log
 // files according to the configuration.
+//
+// Rotate and Write are serialized, so a Write called after Rotate has returned
+// always goes to the new file, even while other goroutines are writing. Writes
+// that run concurrently with Rotate land in either file, never split across both.
 func (l *Logger) Rotate() error {
+	_, err := l.RotateWithInfo()
+	return err
+}
+
+// RotateWithInfo is like Rotate, but also returns the path of the backup the log
+// file was moved to, or "" if there was no log file to move (e.g. nothing has
+// been written yet). The path is that of the uncompressed backup even with
+// Compress, as compression happens later in the background.
+func (l *Logger) RotateWithInfo() (backupPath string, err error) {
+	l.mu.Lock()
+	defer l.unlockAndNotify()
+	if atomic.LoadUint32(&l.isClosed) == 1 {
+		return "", ErrClosed
+	}
+	return l.rotateBackup(ReasonManual)
+}
+
+// StartBackgroundWorkers starts the Logger's background goroutines right away
+// instead of on first use: the cleanup goroutine, and the goroutines for
+// RotateAtMinutes/RotateOnWeekdays, TriggerFile and MaxFileAge if configured.
+// It is for services that want all their goroutines running from startup. It
+// is safe to call more than once, and Close stops the goroutines as usual.
+func (l *Logger) StartBackgroundWorkers() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	if atomic.LoadUint32(&l.isClosed) == 1 {
-		return errors.New("logger closed")
+		return ErrClosed
+	}
+	l.ensureMillRunning()
+	l.ensureScheduledRotationLoopRunning()
+	l.ensureTriggerWatcherRunning()
+	l.ensureFileAgeWatcherRunning()
+	l.ensureFlusherRunning()
+	return nil
+}
+
+// Flush writes any buffered writes (BufferSize) out to the current log file.
+// Unlike Sync, it doesn't wait for the data to reach stable storage, so it is
+// cheap enough to call, e.g., after each batch of log lines. It returns ErrClosed
+// after Close.
+func (l *Logger) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if atomic.LoadUint32(&l.isClosed) == 1 {
+		return ErrClosed
+	}
+	return l.flushBuffer()
+}
+
+// Sync flushes any buffered writes (BufferSize) and commits the current log
+// file to stable storage with fsync. It never rotates the file, even if a
+// rotation is due. It returns ErrClosed after Close.
+func (l *Logger) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if atomic.LoadUint32(&l.isClosed) == 1 {
+		return ErrClosed
+	}
+	if l.file == nil {
+		return nil // Nothing written yet.
+	}
+	if err := l.flushBuffer(); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// LastRotationReason reports what triggered the most recent rotation and when it
+// happened. It returns the zero RotationReason and time if the Logger hasn't
+// rotated yet.
+func (l *Logger) LastRotationReason() (reason RotationReason, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastReason, l.lastReasonAt
+}
+
+// tailBufferLimit is how many bytes of writes a Tail reader may fall behind
+// before it is ended with ErrTailOverrun.
+const tailBufferLimit = 1 << 20
+
+// Tail returns a reader over the last n bytes of the current log file, followed
+// by everything written to it from then on. This allows e.g. serving live logs
+// from an admin endpoint. The reader returns io.EOF once the file is rotated,
+// the Logger is closed, ctx is done or the reader is closed; a Tail doesn't
+// follow into the next file. A reader that falls too far behind the writes
+// fails with ErrTailOverrun instead of holding up Write.
+func (l *Logger) Tail(ctx context.Context, n int) (io.ReadCloser, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if atomic.LoadUint32(&l.isClosed) == 1 {
+		return nil, ErrClosed
+	}
+
+	if err := l.flushBuffer(); err != nil {
+		return nil, err
+	}
+	initial, err := readLastBytes(l.filename(), int64(n))
+	if err != nil {
+		return nil, err
+	}
+	t := &tailReader{l: l, buf: initial, notify: make(chan struct{}, 1), done: make(chan struct{})}
+	if l.tails == nil {
+		l.tails = make(map[*tailReader]struct{})
+	}
+	l.tails[t] = struct{}{}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = t.Close()
+		case <-t.done:
+		}
+	}()
+	return t, nil
+}
+
+// readLastBytes returns up to the last n bytes of the file at path, or nothing
+// if it doesn't exist.
+func readLastBytes(path string, n int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file %s: %w", path, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat log file %s: %w", path, err)
+	}
+	if n > info.Size() {
+		n = info.Size()
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+	buf := make([]byte, n)
+	if _, err := f.ReadAt(buf, info.Size()-n); err != nil {
+		return nil, fmt.Errorf("failed to read log file %s: %w", path, err)
+	}
+	return buf, nil
+}
+
+// feedTails passes bytes just written to the current file on to every Tail.
+// It expects l.mu to be held.
+func (l *Logger) feedTails(p []byte) {
+	for t := range l.tails {
+		t.push(p)
+	}
+}
+
+// endTails ends every Tail, e.g. because the file it follows was rotated.
+// It expects l.mu to be held.
+func (l *Logger) endTails() {
+	for t := range l.tails {
+		t.end(io.EOF)
+	}
+	l.tails = nil
+}
+
+// tailReader is the io.ReadCloser returned by Tail.
+type tailReader struct {
+	l      *Logger
+	mu     sync.Mutex
+	buf    []byte        // bytes not yet read
+	err    error         // returned once buf is drained; set when the tail ends
+	notify chan struct{} // signals Read that buf or err changed
+	done   chan struct{} // closed when the tail ends
+}
+
+// push appends p for the reader, or ends the tail if it has fallen too far behind.
+func (t *tailReader) push(p []byte) {
+	t.mu.Lock()
+	if t.err == nil && len(t.buf)+len(p) > tailBufferLimit {
+		t.mu.Unlock()
+		t.end(ErrTailOverrun)
+		delete(t.l.tails, t)
+		return
+	}
+	if t.err == nil {
+		t.buf = append(t.buf, p...)
+	}
+	t.mu.Unlock()
+	t.signal()
+}
+
+// end makes Read return err once the remaining buffered bytes have been read.
+func (t *tailReader) end(err error) {
+	t.mu.Lock()
+	if t.err == nil {
+		t.err = err
+		close(t.done)
+	}
+	t.mu.Unlock()
+	t.signal()
+}
+
+func (t *tailReader) signal() {
+	select {
+	case t.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Read implements io.Reader, blocking until data is written or the tail ends.
+func (t *tailReader) Read(p []byte) (int, error) {
+	for {
+		t.mu.Lock()
+		if len(t.buf) > 0 {
+			n := copy(p, t.buf)
+			t.buf = t.buf[n:]
+			t.mu.Unlock()
+			return n, nil
+		}
+		if t.err != nil {
+			err := t.err
+			t.mu.Unlock()
+			return 0, err
+		}
+		t.mu.Unlock()
+		<-t.notify
+	}
+}
+
+// Close implements io.Closer, ending the tail.
+func (t *tailReader) Close() error {
+	t.l.mu.Lock()
+	delete(t.l.tails, t)
+	t.l.mu.Unlock()
+	t.end(io.EOF)
+	return nil
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]*Logger) // absolute filename -> Logger from OpenExclusive
+)
+
+// OpenExclusive sets cfg.Filename to filename and returns cfg, after making sure
+// that no other Logger obtained from OpenExclusive in this process manages the
+// same file; otherwise it returns an error wrapping ErrFileInUse. Two Loggers
+// writing the same file would race on rotation and clobber each other's
+// backups. The file is released again when the Logger is closed.
+// cfg is taken by pointer because a Logger must not be copied.
+func OpenExclusive(filename string, cfg *Logger) (*Logger, error) {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve log file path %s: %w", filename, err)
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[path]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrFileInUse, path)
+	}
+	cfg.Filename = filename
+	cfg.registeredPath = path
+	registry[path] = cfg
+	return cfg, nil
+}
+
+// unregister releases the Logger's file in the OpenExclusive registry.
+func (l *Logger) unregister() {
+	if l.registeredPath == "" {
+		return
+	}
+	registryMu.Lock()
+	if registry[l.registeredPath] == l {
+		delete(registry, l.registeredPath)
+	}
+	registryMu.Unlock()
+}
+
+// New checks cfg and returns it ready for use. It returns ErrFilenameRequired
+// if cfg.RequireFilename is set but cfg.Filename is empty.
+// cfg is taken by pointer because a Logger must not be copied.
+func New(cfg *Logger) (*Logger, error) {
+	if cfg.RequireFilename && cfg.Filename == "" {
+		return nil, ErrFilenameRequired
+	}
+	return cfg, nil
+}
+
+// SetFile adopts f, an already-open file, as the active log file. This is useful when
+// the descriptor is handed over by another process, e.g. systemd file descriptor
+// passing. Filename is set to f.Name(), which must therefore be the file's path, so
+// that rotations rename it like any other log file. The current size is taken from
+// f's metadata and further writes are appended to the end of the file.
+//
+// The Logger takes ownership of f: it is closed on the next rotation or on Close.
+// Any previously open log file is closed first.
+func (l *Logger) SetFile(f *os.File) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if atomic.LoadUint32(&l.isClosed) == 1 {
+		return ErrClosed
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat adopted file %s: %w", f.Name(), err)
+	}
+	if info.Mode().IsRegular() {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			return fmt.Errorf("failed to seek to end of adopted file %s: %w", f.Name(), err)
+		}
+	}
+	if err := l.closeFile(); err != nil {
+		return err
+	}
+
+	l.Filename = f.Name()
+	l.file = f
+	l.size = info.Size()
+	now := l.clockNow()
+	if l.logStartTime.IsZero() {
+		l.logStartTime = now
+	}
+	if l.lastRotationTime.IsZero() {
+		// Start interval/minute checks from the adoption, as for a regular first open.
+		l.lastRotationTime = now.In(l.location())
+	}
+	return nil
+}
+
+// Reopen closes the current log file and opens the file at Filename again,
+// creating it if it no longer exists. It is for cooperating with an external
+// rotator such as logrotate: after it has moved the log file aside (or copied
+// and truncated it), Reopen makes subsequent writes go to the file now at
+// Filename. Unlike Rotate, it never moves a file, creates no backup and leaves
+// the time of the last rotation, and thus RotationInterval, unchanged.
+func (l *Logger) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if atomic.LoadUint32(&l.isClosed) == 1 {
+		return ErrClosed
+	}
+	if err := l.closeFile(); err != nil {
+		return err
+	}
+	l.endTails()
+
+	if err := os.MkdirAll(l.dir(), 0755); err != nil {
+		return fmt.Errorf("can't make directories for logfile: %s", err)
+	}
+	file, err := osOpenFile(l.filename(), os.O_CREATE|os.O_APPEND|os.O_WRONLY|l.OpenFlags, l.fileMode(0600))
+	if err != nil {
+		return fmt.Errorf("can't reopen logfile: %s", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("can't stat reopened logfile: %s", err)
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// RotateTo closes the current log file, moves it to exactly destPath (creating
+// any missing parent directories) and opens a fresh log file under the original
+// filename. It is intended for "harvesting" the active file to a location the
+// caller controls, e.g. for an immediate upload.
+//
+// The backup naming scheme is bypassed, so the file at destPath is not managed by
+// cleanup: it is never compressed or removed because of MaxBackups or MaxAge.
+// Cleanup of the regular backups is still triggered afterwards. Otherwise it is
+// a manual rotation like Rotate, with the same hooks and bookkeeping, such as
+// OnRotate, AuditFile and SyncBackupBeforeHook.
+func (l *Logger) RotateTo(destPath string) error {
+	l.mu.Lock()
+	defer l.unlockAndNotify()
+	if atomic.LoadUint32(&l.isClosed) == 1 {
+		return ErrClosed
+	}
+	if destPath == "" {
+		return errors.New("timberjack: empty destination path")
+	}
+	_, err := l.rotateBackupTo(ReasonManual, destPath)
+	return err
+}
+
+// rotate closes the current file, moves it aside with a timestamp in the name,
+// (if it exists), opens a new file with the original filename, and then runs
+// post-rotation processing and removal (mill).
+// It expects l.mu to be held by the caller.
+// The reason is passed to OnRotate and determines the reason in the backup filename.
+func (l *Logger) rotate(reason RotationReason) error {
+	_, err := l.rotateBackup(reason)
+	return err
+}
+
+// rotateBackup performs rotate and returns the path of the backup, if any.
+func (l *Logger) rotateBackup(reason RotationReason) (string, error) {
+	return l.rotateBackupTo(reason, "")
+}
+
+// rotateBackupTo is rotateBackup moving the file to destPath, or to a backup
+// named by the naming scheme if destPath is empty. Every rotation goes through
+// it, so that they all get the same hooks, accounting and cleanup.
+func (l *Logger) rotateBackupTo(reason RotationReason, destPath string) (string, error) {
+	if l.passthrough {
+		return "", nil
+	}
+	if l.TrackLatency {
+		start := latencyNow()
+		defer func() { l.stats.rotationLatency.observe(latencyNow().Sub(start)) }()
+	}
+	if l.RotationBufferSize > 0 {
+		l.setRotating()
+		defer l.flushRotationBuffer()
+	}
+	if err := l.closeFile(); err != nil {
+		l.recordFailure(fmt.Sprintf("rotation failed: %v", err))
+		l.audit("rotate", "", reason.String(), err)
+		return "", err
+	}
+	backupPath, err := l.openNewAs(l.backupReason(reason), destPath)
+	if err != nil {
+		l.recordFailure(fmt.Sprintf("rotation failed: %v", err))
+		l.audit("rotate", "", reason.String(), err)
+		return "", err
+	}
+	l.recordSuccess()
+	l.writeCount = 0
+	l.audit("rotate", backupPath, reason.String(), nil)
+	l.lastReason, l.lastReasonAt = reason, l.now()
+	if l.MaxRotationsPerMinute > 0 {
+		l.rollRotationWindow()
+		l.windowRotations++
+	}
+	l.endTails()
+	if l.SyncBackupBeforeHook && backupPath != "" {
+		if err := syncPath(backupPath); err != nil {
+			err = fmt.Errorf("failed to sync backup %s: %w", backupPath, err)
+			fmt.Fprintf(os.Stderr, "timberjack: [%s] %v\n", l.Filename, err)
+			l.reportError(err)
+		}
+	}
+	l.notifyRotate(reason, backupPath)
+	if l.DropCacheOnRotate && backupPath != "" {
+		l.dropCacheMu.Lock()
+		l.dropCachePending = append(l.dropCachePending, backupPath)
+		l.dropCacheMu.Unlock()
+	}
+	l.persistState()
+	l.mill() // Trigger backup processing (compression, cleanup)
+	return backupPath, nil
+}
+
+// backupReason returns the reason used in the backup filename for a rotation
+// triggered by reason. It expects l.mu to be held.
+func (l *Logger) backupReason(reason RotationReason) string {
+	switch reason {
+	case ReasonTime:
+		return "time"
+	case ReasonCount:
+		return "count"
+	case ReasonStartup:
+		return l.startupReason()
+	case ReasonManual:
+		// Manual rotations are labelled "time" if an interval rotation is also
+		// due at this moment, and "size" otherwise.
+		if l.shouldTimeRotate() {
+			return "time"
+		}
+		return "size"
+	default:
+		return "size"
+	}
+}
+
+// RotationEvent describes a rotation, as delivered by Events.
+type RotationEvent struct {
+	Time       time.Time      // when the rotation happened
+	Reason     RotationReason // what triggered it
+	BackupPath string         // where the log file was moved, or "" if there was none
+	Size       int64          // size of the backup in bytes
+}
+
+// eventsBufferSize is the capacity of the channel returned by Events.
+const eventsBufferSize = 64
+
+// Events returns a channel that receives a RotationEvent for every rotation
+// from then on. Every call returns the same channel. Events are sent without
+// blocking, so if the channel's buffer is full because they aren't received
+// fast enough, they are dropped rather than holding up Write. The channel is
+// closed by Close; after Close, Events returns a closed channel.
+func (l *Logger) Events() <-chan RotationEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.events == nil {
+		l.events = make(chan RotationEvent, eventsBufferSize)
+		if atomic.LoadUint32(&l.isClosed) == 1 {
+			close(l.events)
+		}
+	}
+	return l.events
+}
+
+// rotateEvent is a rotation waiting to be passed to OnRotate.
+type rotateEvent struct {
+	reason     RotationReason
+	backupPath string
+	done       chan struct{} // closed once OnRotate has returned for the rotation
+}
+
+// notifyRotate queues a call to OnRotate, if set, which unlockAndNotify makes
+// once l.mu is released. It expects l.mu to be held.
+func (l *Logger) notifyRotate(reason RotationReason, backupPath string) {
+	if l.OnRotate != nil {
+		l.pendingRotations = append(l.pendingRotations, rotateEvent{reason, backupPath, make(chan struct{})})
+	}
+	if l.events == nil && l.WebhookFunc == nil {
+		return
+	}
+	ev := l.rotationEvent(reason, backupPath)
+	if l.events != nil {
+		select {
+		case l.events <- ev:
+		default: // Full; drop the event rather than block.
+		}
+	}
+	if l.WebhookFunc != nil {
+		l.queueWebhook(ev)
+	}
+}
+
+// rotationEvent describes the rotation that just moved the log file to
+// backupPath. It expects l.mu to be held.
+func (l *Logger) rotationEvent(reason RotationReason, backupPath string) RotationEvent {
+	ev := RotationEvent{Time: l.lastReasonAt, Reason: reason, BackupPath: backupPath}
+	if backupPath != "" {
+		if info, err := osStat(backupPath); err == nil {
+			ev.Size = info.Size()
+		}
+	}
+	return ev
+}
+
+const (
+	webhookWorkers        = 4  // goroutines calling WebhookFunc
+	webhookQueueSize      = 64 // events waiting for a free goroutine
+	defaultWebhookRetries = 3
+)
+
+// queueWebhook hands ev to the webhook goroutines, starting them if needed. It
+// expects l.mu to be held.
+func (l *Logger) queueWebhook(ev RotationEvent) {
+	l.startWebhooksOnce.Do(func() {
+		l.webhookCh = make(chan RotationEvent, webhookQueueSize)
+		for i := 0; i < webhookWorkers; i++ {
+			go l.runWebhooks(l.webhookCh)
+		}
+	})
+	if l.webhookCh == nil {
+		return // Closed.
+	}
+	select {
+	case l.webhookCh <- ev:
+	default:
+		err := fmt.Errorf("webhook queue full, dropped event for %s", ev.BackupPath)
+		fmt.Fprintf(os.Stderr, "timberjack: [%s] %v\n", l.Filename, err)
+		l.reportError(err)
+	}
+}
+
+// runWebhooks calls WebhookFunc for the events received on ch until it is closed.
+// ch is passed in because Close clears webhookCh.
+func (l *Logger) runWebhooks(ch chan RotationEvent) {
+	for ev := range ch {
+		l.callWebhook(ev)
+	}
+}
+
+// callWebhook calls WebhookFunc for ev, retrying failed calls.
+func (l *Logger) callWebhook(ev RotationEvent) {
+	retries := l.WebhookMaxRetries
+	if retries <= 0 {
+		retries = defaultWebhookRetries
+	}
+	delay := webhookRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := l.WebhookFunc(ev)
+		if err == nil {
+			return
+		}
+		if attempt == retries {
+			err = fmt.Errorf("webhook failed after %d attempts: %w", attempt+1, err)
+			fmt.Fprintf(os.Stderr, "timberjack: [%s] %v\n", l.Filename, err)
+			l.reportError(err)
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// unlockAndNotify releases l.mu and then calls OnRotate for the rotations made
+// while it was held, returning once those calls have been made. Calls are made
+// one at a time and in rotation order: if another goroutine is already running
+// OnRotate, the events are left to it and waited for. If that goroutine is this
+// one, i.e. the hook itself caused a rotation, they are not waited for, as they
+// can only be delivered once the hook returns.
+func (l *Logger) unlockAndNotify() {
+	pending := l.pendingRotations
+	l.pendingRotations = nil
+	if len(pending) == 0 {
+		l.mu.Unlock()
+		return
+	}
+
+	// Queue under l.mu, so the queue is in rotation order.
+	id := goroutineID()
+	l.hookMu.Lock()
+	l.hookQueue = append(l.hookQueue, pending...)
+	if l.hookRunning {
+		nested := l.hookGoroutine == id
+		l.hookMu.Unlock()
+		l.mu.Unlock()
+		if !nested {
+			<-pending[len(pending)-1].done
+		}
+		return
+	}
+	l.hookRunning = true
+	l.hookGoroutine = id
+	l.hookMu.Unlock()
+	l.mu.Unlock()
+
+	for {
+		l.hookMu.Lock()
+		if len(l.hookQueue) == 0 {
+			l.hookRunning = false
+			l.hookMu.Unlock()
+			return
+		}
+		ev := l.hookQueue[0]
+		l.hookQueue = l.hookQueue[1:]
+		l.hookMu.Unlock()
+		l.callOnRotate(ev)
+		close(ev.done)
+	}
+}
+
+// goroutineID returns the id of the calling goroutine, parsed from the header
+// of its stack trace ("goroutine 18 [running]:").
+func goroutineID() uint64 {
+	var buf [64]byte
+	fields := bytes.Fields(buf[:runtime.Stack(buf[:], false)])
+	if len(fields) < 2 {
+		return 0
+	}
+	id, _ := strconv.ParseUint(string(fields[1]), 10, 64)
+	return id
+}
+
+// callOnRotate calls OnRotate for ev, recovering from a panic in it so that it
+// can't take down the goroutine that rotated.
+func (l *Logger) callOnRotate(ev rotateEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := fmt.Errorf("OnRotate panicked: %v", r)
+			fmt.Fprintf(os.Stderr, "timberjack: [%s] %v\n", l.Filename, err)
+			l.reportError(err)
+		}
+	}()
+	l.OnRotate(ev.reason, ev.backupPath)
+}
+
+// setRotating marks a rotation as in progress, so that concurrent writes are
+// buffered instead of waiting for l.mu.
+func (l *Logger) setRotating() {
+	l.rotationBufMu.Lock()
+	l.rotating = true
+	l.rotationBufMu.Unlock()
+}
+
+// bufferDuringRotation appends p to the rotation buffer if a rotation is in
+// progress and p fits in the remaining RotationBufferSize. It reports whether p
+// was buffered.
+func (l *Logger) bufferDuringRotation(p []byte) bool {
+	l.rotationBufMu.Lock()
+	defer l.rotationBufMu.Unlock()
+
+	if !l.rotating || len(l.rotationBuf)+len(p) > l.RotationBufferSize {
+		return false
+	}
+	l.rotationBuf = append(l.rotationBuf, p...)
+	return true
+}
+
+// flushRotationBuffer ends the rotation window and writes any buffered data to the
+// current log file. If the rotation failed and no file is open, the data is
+// appended to the log file directly so it isn't lost.
+// It expects l.mu to be held.
+func (l *Logger) flushRotationBuffer() {
+	l.rotationBufMu.Lock()
+	buf := l.rotationBuf
+	l.rotationBuf = nil
+	l.rotating = false
+	l.rotationBufMu.Unlock()
+
+	if len(buf) == 0 {
+		return
+	}
+	if l.file != nil {
+		n, err := l.writeFile(buf)
+		l.size += int64(n)
+		l.feedTails(buf[:n])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "timberjack: [%s] failed to flush writes buffered during rotation: %v\n", l.Filename, err)
+		}
+		return
+	}
+	file, err := os.OpenFile(l.filename(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, l.fileMode(0644))
+	if err == nil {
+		_, err = file.Write(buf)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "timberjack: [%s] failed to flush writes buffered during rotation: %v\n", l.Filename, err)
+	}
+}
+
+// openNew creates a new log file for writing.
+// If an old log file already exists, it is moved aside by renaming it with a timestamp.
+// This method assumes that l.mu is held and the old file (if any) has already been closed.
+// The reasonForBackup parameter is used in the backup filename.
+func (l *Logger) openNew(reasonForBackup string) error {
+	_, err := l.openNewAs(reasonForBackup, "")
+	return err
+}
+
+// openNewAs is like openNew, but if destPath is non-empty an existing log file is
+// moved to exactly that path (creating its parent directories) instead of to a
+// timestamped backup name. It returns the path the old log file was moved to, or
+// "" if there was none.
+func (l *Logger) openNewAs(reasonForBackup, destPath string) (string, error) {
+	if err := l.validateOpenFlags(); err != nil {
+		return "", err
+	}
+	err := os.MkdirAll(l.dir(), 0755)
+	if err != nil {
+		return "", fmt.Errorf("can't make directories for new logfile: %s", err)
+	}
+
+	name := l.filename()
+	finalMode := l.fileMode(0600)
+	var oldInfo os.FileInfo
+	var backupPath string
+
+	info, err := osStat(name)
+	if err == nil {
+		oldInfo = info
+		finalMode = oldInfo.Mode()
+
+		rotationTimeForBackup := l.now()
+
+		l.validateBackupTimeFormatOnce()
+
+		newname := destPath
+		if newname == "" && l.RingSize > 0 {
+			newname = l.ringSlot()
+		} else if newname == "" {
+			nameReason := reasonForBackup
+			if l.OmitReasonInName {
+				nameReason = ""
+			}
+			newname = backupName(name, l.LocalTime, nameReason, rotationTimeForBackup, l.BackupTimeFormat)
+		} else if errDir := os.MkdirAll(filepath.Dir(newname), 0755); errDir != nil {
+			return "", fmt.Errorf("can't make directories for %s: %s", newname, errDir)
+		}
+		if errRename := renameOrCopy(name, newname); errRename != nil {
+			return "", fmt.Errorf("can't rename log file: %s", errRename)
+		}
+		backupPath = newname
+		l.logStartTime = rotationTimeForBackup
+		if l.WriteMetaSidecar && destPath == "" {
+			meta := backupMeta{Reason: reasonForBackup, Timestamp: rotationTimeForBackup, Size: oldInfo.Size()}
+			if errMeta := writeBackupMeta(newname, meta); errMeta != nil {
+				fmt.Fprintf(os.Stderr, "timberjack: [%s] failed to write metadata for %s: %v\n", l.Filename, newname, errMeta)
+			}
+		}
+	} else if os.IsNotExist(err) {
+		l.logStartTime = l.now()
+		oldInfo = nil
+	} else {
+		return "", fmt.Errorf("failed to stat log file %s: %w", name, err)
+	}
+
+	// Create and open the new log file at path `name`.
+	f, err := osOpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|l.OpenFlags, finalMode)
+	if err != nil {
+		return "", fmt.Errorf("can't open new logfile %s: %s", name, err)
+	}
+	l.file = f
+	l.size = 0
+
+	// Now that the new file `name` is created, if there was an old file, try to chown the new one.
+	if oldInfo != nil {
+		if errChown := chown(name, oldInfo); errChown != nil {
+			fmt.Fprintf(os.Stderr, "timberjack: [%s] failed to chown new log file %s: %v\n", l.Filename, name, errChown)
+		}
+	}
+	return backupPath, nil
+}
+
+// ringSlot returns the RingSize slot the current file is rotated into and
+// advances the ring. On the first rotation, the ring starts at the first unused
+// slot, or else at the one written longest ago.
+// It expects l.mu to be held.
+func (l *Logger) ringSlot() string {
+	name := l.filename()
+	if !l.ringPositioned {
+		l.ringNext = l.oldestRingSlot()
+		l.ringPositioned = true
+	}
+	slot := fmt.Sprintf("%s.%d", name, l.ringNext%l.RingSize)
+	l.ringNext = (l.ringNext + 1) % l.RingSize
+	_ = osRemove(slot) // rename doesn't replace an existing file everywhere
+	return slot
+}
+
+// oldestRingSlot returns the index of the first unused RingSize slot, or else of
+// the one written longest ago.
+func (l *Logger) oldestRingSlot() int {
+	oldest := 0
+	var oldestTime time.Time
+	for i := 0; i < l.RingSize; i++ {
+		info, err := osStat(fmt.Sprintf("%s.%d", l.filename(), i))
+		if err != nil {
+			return i
+		}
+		if i == 0 || info.ModTime().Before(oldestTime) {
+			oldest, oldestTime = i, info.ModTime()
+		}
+	}
+	return oldest
+}
+
+// allowSizeRotation reports whether a size rotation may happen now under
+// MaxRotationsPerMinute, reporting the first suppression in each window.
+// It expects l.mu to be held.
+func (l *Logger) allowSizeRotation() bool {
+	if l.MaxRotationsPerMinute <= 0 {
+		return true
+	}
+	l.rollRotationWindow()
+	if l.windowRotations < l.MaxRotationsPerMinute {
+		return true
+	}
+	if !l.windowWarned {
+		l.windowWarned = true
+		err := fmt.Errorf("%w: %d rotations in the last minute, size rotation suppressed", ErrRotationRateLimited, l.windowRotations)
+		fmt.Fprintf(os.Stderr, "timberjack: [%s] %v\n", l.Filename, err)
+		l.reportError(err)
+	}
+	return false
+}
+
+// rollRotationWindow starts a new MaxRotationsPerMinute window if the current
+// one is over. It expects l.mu to be held.
+func (l *Logger) rollRotationWindow() {
+	now := l.now()
+	if l.windowStart.IsZero() || now.Sub(l.windowStart) >= time.Minute || now.Before(l.windowStart) {
+		l.windowStart = now
+		l.windowRotations = 0
+		l.windowWarned = false
+	}
+}
+
+// maxBackoffFactor caps the DirUnavailableBackoff delay at this multiple of the
+// configured value.
+const maxBackoffFactor = 64
+
+// inBackoff reports whether opening the log file is being held off after failures.
+// It expects l.mu to be held.
+func (l *Logger) inBackoff() bool {
+	return !l.backoffUntil.IsZero() && l.clockNow().Before(l.backoffUntil)
+}
+
+// holdDuringBackoff keeps p for later if it fits in BackoffBufferSize, and drops
+// it otherwise. It expects l.mu to be held.
+func (l *Logger) holdDuringBackoff(p []byte) (int, error) {
+	if len(l.backoffBuf)+len(p) > l.BackoffBufferSize {
+		return 0, ErrDirUnavailable
+	}
+	l.backoffBuf = append(l.backoffBuf, p...)
+	return len(p), nil
+}
+
+// backOff records a failure to open or write the log file and schedules the next
+// attempt to open it. It expects l.mu to be held.
+func (l *Logger) backOff(err error) {
+	if l.backoffDelay == 0 {
+		l.backoffDelay = l.DirUnavailableBackoff
+	} else if l.backoffDelay < maxBackoffFactor*l.DirUnavailableBackoff {
+		l.backoffDelay *= 2
+	}
+	l.backoffUntil = l.clockNow().Add(l.backoffDelay)
+	l.reportError(fmt.Errorf("timberjack: log file unavailable, retrying in %v: %w", l.backoffDelay, err))
+}
+
+// endBackoff resets the backoff once the log file has been opened and writes out
+// anything held in the meantime. It expects l.mu to be held.
+func (l *Logger) endBackoff() {
+	l.backoffDelay = 0
+	l.backoffUntil = time.Time{}
+	if len(l.backoffBuf) == 0 {
+		return
+	}
+	n, err := l.writeFile(l.backoffBuf)
+	l.size += int64(n)
+	l.feedTails(l.backoffBuf[:n])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "timberjack: [%s] failed to write %d bytes held during backoff: %v\n", l.Filename, len(l.backoffBuf), err)
 	}
-	// Determine reason for manual Rotate to align with test expectations and original behavior:
-	// If an interval rotation is also due at this moment, label it "time".
-	// Otherwise, label it "size" as a general default for manual rotation (tests often expect this).
-	reason := "size"
-	if l.shouldTimeRotate() { // shouldTimeRotate checks RotationInterval based on lastRotationTime
-		reason = "time"
+	l.backoffBuf = nil
+}
+
+// validateOpenFlags rejects OpenFlags that conflict with how the log file must be
+// opened: write-only, and appended to rather than truncated when reopened.
+func (l *Logger) validateOpenFlags() error {
+	if l.OpenFlags&(os.O_RDWR|os.O_TRUNC) != 0 {
+		return fmt.Errorf("%w: %#x includes O_RDWR or O_TRUNC", ErrInvalidOpenFlags, l.OpenFlags)
 	}
-	return l.rotate(reason)
+	return nil
 }
 
-// rotate closes the current file, moves it aside with a timestamp in the name,
-// (if it exists), opens a new file with the original filename, and then runs
-// post-rotation processing and removal (mill).
-// It expects l.mu to be held by the caller.
-// Takes an explicit reason for the rotation which is used in the backup filename.
-func (l *Logger) rotate(reason string) error {
-	if err := l.closeFile(); err != nil {
-		return err
+// now returns the time of the write in progress if it was given to WriteAtTime,
+// and the current time otherwise. It expects l.mu to be held.
+func (l *Logger) now() time.Time {
+	if !l.writeTime.IsZero() {
+		return l.writeTime
 	}
-	// Pass the determined reason to openNew so it's used in the backup filename
-	if err := l.openNew(reason); err != nil {
-		return err
+	return l.clockNow()
+}
+
+// clockNow returns the current time according to Clock, or the system clock if
+// Clock is nil.
+func (l *Logger) clockNow() time.Time {
+	if l.Clock != nil {
+		return l.Clock.Now()
 	}
-	l.mill() // Trigger backup processing (compression, cleanup)
-	return nil
+	return currentTime()
 }
 
-// openNew creates a new log file for writing.
-// If an old log file already exists, it is moved aside by renaming it with a timestamp.
-// This method assumes that l.mu is held and the old file (if any) has already been closed.
-// The reasonForBackup parameter is used in the backup filename.
-func (l *Logger) openNew(reasonForBackup string) error {
-	err := os.MkdirAll(l.dir(), 0755)
+// currentSize returns the size of the current file used for MaxSize decisions:
+// the bytes written, or with SizeFromStat the space it takes up on disk.
+// It expects l.mu to be held and the file to be open.
+func (l *Logger) currentSize() int64 {
+	if !l.SizeFromStat {
+		return l.size
+	}
+	info, err := l.file.Stat()
 	if err != nil {
-		return fmt.Errorf("can't make directories for new logfile: %s", err)
+		return l.size
 	}
+	if l.buf != nil {
+		return onDiskSize(info) + int64(l.buf.Buffered())
+	}
+	return onDiskSize(info)
+}
 
-	name := l.filename()
-	finalMode := os.FileMode(0600)
-	var oldInfo os.FileInfo
-
-	info, err := osStat(name)
-	if err == nil {
-		oldInfo = info
-		finalMode = oldInfo.Mode()
+// checkFreeSpace returns ErrInsufficientDiskSpace if less than MinFreeBytes are
+// free on the filesystem of the log file. If the free space can't be determined,
+// the write is let through.
+func (l *Logger) checkFreeSpace() error {
+	free, err := freeBytes(l.dir())
+	if err != nil || free >= l.MinFreeBytes {
+		return nil
+	}
+	return fmt.Errorf("%w: %d bytes free in %s, MinFreeBytes is %d", ErrInsufficientDiskSpace, free, l.dir(), l.MinFreeBytes)
+}
 
-		rotationTimeForBackup := currentTime()
-
-		if !l.isBackupTimeFormatValidated {
-			// a backup format has been supplied.
-			validationErr := l.ValidateBackupTimeFormat()
-			if validationErr != nil {
-				// some validation issue.
-				// backup format is empty or invalid.
-				// use backupformat constant
-				l.BackupTimeFormat = backupTimeFormat
-				fmt.Fprintf(os.Stderr, "timberjack: invalid BackupTimeFormat: %v — falling back to default format: %s\n", validationErr, backupTimeFormat)
-			}
-			// mark the backup format as validated if there was no error.
-			// this would prevent validation checks in every rotation
-			l.isBackupTimeFormatValidated = true
-		}
+// reconcileSize sets the tracked size to that of the file on disk plus what is
+// still buffered, for ReconcileSizeOnWrite. It expects l.mu to be held and the
+// file to be open.
+func (l *Logger) reconcileSize() {
+	info, err := l.file.Stat()
+	if err != nil {
+		return
+	}
+	size := info.Size()
+	if l.buf != nil {
+		size += int64(l.buf.Buffered())
+	}
+	if size == l.size {
+		return
+	}
+	// A new file isn't opened with O_APPEND, so after a truncation writes would
+	// continue at the old offset, leaving a hole. Move to the end of the file.
+	if _, err := l.file.Seek(0, io.SeekEnd); err != nil {
+		return
+	}
+	l.size = size
+}
 
-		newname := backupName(name, l.LocalTime, reasonForBackup, rotationTimeForBackup, l.BackupTimeFormat)
-		if errRename := osRename(name, newname); errRename != nil {
-			return fmt.Errorf("can't rename log file: %s", errRename)
-		}
-		l.logStartTime = rotationTimeForBackup
-	} else if os.IsNotExist(err) {
-		l.logStartTime = currentTime()
-		oldInfo = nil
-	} else {
-		return fmt.Errorf("failed to stat log file %s: %w", name, err)
+// atRotationBoundary reports whether LineAware and RotateBoundaryFunc (if set)
+// approve rotating the current file, based on its last bytes. Failing to read
+// the file never blocks a rotation. It expects l.mu to be held.
+func (l *Logger) atRotationBoundary() bool {
+	if (l.RotateBoundaryFunc == nil && !l.LineAware) || l.size == 0 {
+		return true
 	}
 
-	// Create and open the new log file at path `name`.
-	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, finalMode)
+	if err := l.flushBuffer(); err != nil {
+		return true
+	}
+	n := l.size
+	if l.RotateBoundaryFunc == nil {
+		n = 1 // LineAware only needs the last byte.
+	} else if n > boundaryTailSize {
+		n = boundaryTailSize
+	}
+	f, err := os.Open(l.filename())
 	if err != nil {
-		return fmt.Errorf("can't open new logfile %s: %s", name, err)
+		return true
 	}
-	l.file = f
-	l.size = 0
+	defer f.Close()
 
-	// Now that the new file `name` is created, if there was an old file, try to chown the new one.
-	if oldInfo != nil {
-		if errChown := chown(name, oldInfo); errChown != nil {
-			fmt.Fprintf(os.Stderr, "timberjack: [%s] failed to chown new log file %s: %v\n", l.Filename, name, errChown)
-		}
+	tail := make([]byte, n)
+	read, err := f.ReadAt(tail, l.size-n)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return true
 	}
-	return nil
+	tail = tail[:read]
+	if l.LineAware && len(tail) > 0 && tail[len(tail)-1] != '\n' {
+		return false
+	}
+	return l.RotateBoundaryFunc == nil || l.RotateBoundaryFunc(tail)
 }
 
 // shouldTimeRotate checks if the time-based rotation interval has elapsed
@@ -590,11 +2908,46 @@ func (l *Logger) shouldTimeRotate() bool {
 	if l.lastRotationTime.IsZero() {
 		return false
 	}
-	return currentTime().Sub(l.lastRotationTime) >= l.RotationInterval
+	return l.intervalElapsed(l.now())
+}
+
+// intervalElapsed reports whether RotationInterval, extended by the current
+// jitter, has passed between the last rotation and now.
+func (l *Logger) intervalElapsed(now time.Time) bool {
+	if l.AlignInterval {
+		return !now.Before(l.alignedDeadline().Add(l.rotationJitter))
+	}
+	return now.Sub(l.lastRotationTime) >= l.RotationInterval+l.rotationJitter
+}
+
+// alignedDeadline returns the first multiple of RotationInterval, counted from
+// the midnight starting the day of the last rotation, that comes after it.
+func (l *Logger) alignedDeadline() time.Time {
+	last := l.lastRotationTime.In(l.location())
+	midnight := time.Date(last.Year(), last.Month(), last.Day(), 0, 0, 0, 0, l.location())
+	periods := last.Sub(midnight)/l.RotationInterval + 1
+	return midnight.Add(periods * l.RotationInterval)
+}
+
+// nextJitter draws the random delay for the next RotationInterval period.
+// It expects l.mu to be held.
+func (l *Logger) nextJitter() time.Duration {
+	if l.RotationJitter <= 0 {
+		return 0
+	}
+	r := l.JitterRand
+	if r == nil {
+		if l.jitterRand == nil {
+			l.jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+		}
+		r = l.jitterRand
+	}
+	return time.Duration(r.Int63n(int64(l.RotationJitter)))
 }
 
 // backupName creates a new backup filename by inserting a timestamp and a rotation reason
 // ("time" or "size") between the filename prefix and the extension.
+// An empty reason leaves the reason segment out.
 // It uses the local time if requested (otherwise UTC).
 func backupName(name string, local bool, reason string, t time.Time, fileTimeFormat string) string {
 	dir := filepath.Dir(name)
@@ -608,6 +2961,9 @@ func backupName(name string, local bool, reason string, t time.Time, fileTimeFor
 	}
 	// Format the timestamp for the backup file.
 	timestamp := t.In(currentLoc).Format(fileTimeFormat)
+	if reason == "" {
+		return filepath.Join(dir, fmt.Sprintf("%s-%s%s", prefix, timestamp, ext))
+	}
 	return filepath.Join(dir, fmt.Sprintf("%s-%s-%s%s", prefix, timestamp, reason, ext))
 }
 
@@ -616,8 +2972,18 @@ func backupName(name string, local bool, reason string, t time.Time, fileTimeFor
 // would exceed MaxSize, the current file is rotated (if it exists) and a new logfile is created.
 // It expects l.mu to be held by the caller.
 func (l *Logger) openExistingOrNew(writeLen int) error {
-	l.mill() // Perform house-keeping for old logs (compression, deletion) first.
+	if l.RequireFilename && l.Filename == "" {
+		return ErrFilenameRequired
+	}
+	if err := l.validateOpenFlags(); err != nil {
+		return err
+	}
+	if !l.SkipInitialMill {
+		l.mill() // Perform house-keeping for old logs (compression, deletion) first.
+	}
 
+	l.passthrough = false
+	l.writeCount = 0
 	filename := l.filename()
 	info, err := osStat(filename)
 	if os.IsNotExist(err) {
@@ -630,25 +2996,340 @@ func (l *Logger) openExistingOrNew(writeLen int) error {
 		return fmt.Errorf("error getting log file info: %s", err)
 	}
 
+	if l.NonRegularFilePassthrough && !info.Mode().IsRegular() {
+		file, err := osOpenFile(filename, os.O_APPEND|os.O_WRONLY|l.OpenFlags, 0)
+		if err != nil {
+			return fmt.Errorf("can't open %s for writing: %w", filename, err)
+		}
+		l.file = file
+		l.size = 0
+		l.passthrough = true
+		return nil
+	}
+
+	// Move a leftover file from a previous run aside, once per Logger.
+	if l.RotateOnStart && !l.startupChecked {
+		l.startupChecked = true
+		if info.Size() > 0 {
+			return l.rotate(ReasonStartup)
+		}
+	}
+
 	// Check if rotation is needed due to size before opening/appending.
-	if info.Size()+int64(writeLen) >= l.max() {
-		return l.rotate("size") // This rotation is explicitly due to "size"
+	size := info.Size()
+	if l.SizeFromStat {
+		size = onDiskSize(info)
+	}
+	if size+int64(writeLen) >= l.max() {
+		return l.rotate(ReasonSize) // This rotation is explicitly due to "size"
 	}
 
 	// Open existing file for appending.
-	file, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0644) // Mode 0644 is common for append.
+	file, err := osOpenFile(filename, os.O_APPEND|os.O_WRONLY|l.OpenFlags, 0644) // Mode 0644 is common for append.
 	if err != nil {
+		if l.FailOnAppendOpenError {
+			return fmt.Errorf("can't open existing logfile %s for appending: %w", filename, err)
+		}
 		// If opening existing fails (e.g., permissions, corruption), try to create a new one.
 		return l.openNew("initial") // Fallback if append fails
 	}
 	l.file = file
 	l.size = info.Size()
+	if l.PersistState {
+		l.loadState()
+	}
 	// Note: l.logStartTime is NOT updated here if we successfully open an existing file without rotating.
 	// It retains its value from when this current log segment was created (by a previous openNew).
 	// l.lastRotationTime is also NOT updated here; it's handled by rotation trigger logic.
 	return nil
 }
 
+// loggerState is the content of the PersistState state file.
+type loggerState struct {
+	LogStartTime     time.Time `json:"logStartTime"`
+	LastRotationTime time.Time `json:"lastRotationTime"`
+}
+
+// stateFilename returns the path of the PersistState state file.
+func (l *Logger) stateFilename() string {
+	return l.filename() + ".state"
+}
+
+// auditEntry is one line of the AuditFile.
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"` // "rotate", "remove", "compress" or "upload"
+	File    string    `json:"file,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	Outcome string    `json:"outcome"` // "ok" or "error"
+	Error   string    `json:"error,omitempty"`
+}
+
+// audit appends an entry for event on file to AuditFile, if one is configured.
+// reason is only set for rotations. Failures are reported to stderr.
+func (l *Logger) audit(event, file, reason string, eventErr error) {
+	if l.AuditFile == "" {
+		return
+	}
+	entry := auditEntry{Time: l.clockNow(), Event: event, File: file, Reason: reason, Outcome: "ok"}
+	if eventErr != nil {
+		entry.Outcome, entry.Error = "error", eventErr.Error()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "timberjack: [%s] failed to encode audit entry: %v\n", l.Filename, err)
+		return
+	}
+	data = append(data, '\n')
+
+	// Rotations and cleanup runs append concurrently.
+	l.auditMu.Lock()
+	defer l.auditMu.Unlock()
+	if l.AuditMaxSize > 0 {
+		if info, err := osStat(l.AuditFile); err == nil && info.Size()+int64(len(data)) > int64(l.AuditMaxSize)*int64(megabyte) {
+			if err := osRename(l.AuditFile, l.AuditFile+".1"); err != nil {
+				fmt.Fprintf(os.Stderr, "timberjack: [%s] failed to roll audit file %s: %v\n", l.Filename, l.AuditFile, err)
+			}
+		}
+	}
+	f, err := osOpenFile(l.AuditFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err == nil {
+		_, err = f.Write(data)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "timberjack: [%s] failed to write audit file %s: %v\n", l.Filename, l.AuditFile, err)
+	}
+}
+
+// isAuditFile reports whether name, a file in the log directory, is the
+// AuditFile or its rolled predecessor, so that cleanup never takes it for a backup.
+func (l *Logger) isAuditFile(name string) bool {
+	if l.AuditFile == "" {
+		return false
+	}
+	path := filepath.Clean(filepath.Join(l.dir(), name))
+	audit := filepath.Clean(l.AuditFile)
+	return path == audit || path == audit+".1"
+}
+
+// backupMeta is the content of a backup's metadata sidecar (WriteMetaSidecar).
+type backupMeta struct {
+	Reason     string    `json:"reason"`
+	Timestamp  time.Time `json:"timestamp"`
+	Size       int64     `json:"size"`
+	SHA256     string    `json:"sha256"`
+	Compressed bool      `json:"compressed"`
+}
+
+// metaFilename returns the sidecar path for a backup, compressed or not.
+func metaFilename(backup string) string {
+	return strings.TrimSuffix(backup, compressSuffix) + metaSuffix
+}
+
+// writeBackupMeta checksums the backup file and writes meta to its sidecar.
+func writeBackupMeta(backup string, meta backupMeta) error {
+	f, err := os.Open(backup)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	meta.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return saveBackupMeta(backup, meta)
+}
+
+// saveBackupMeta writes meta to the sidecar of backup.
+func saveBackupMeta(backup string, meta backupMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(metaFilename(backup), data, 0644)
+}
+
+// markBackupMetaCompressed records in the sidecar of backup, if there is one,
+// that it has been compressed.
+func (l *Logger) markBackupMetaCompressed(backup string) {
+	data, err := os.ReadFile(metaFilename(backup))
+	if err != nil {
+		return // No sidecar, e.g. the backup predates WriteMetaSidecar.
+	}
+	var meta backupMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		fmt.Fprintf(os.Stderr, "timberjack: [%s] invalid metadata for %s: %v\n", l.Filename, backup, err)
+		return
+	}
+	meta.Compressed = true
+	if err := saveBackupMeta(backup, meta); err != nil {
+		fmt.Fprintf(os.Stderr, "timberjack: [%s] failed to update metadata for %s: %v\n", l.Filename, backup, err)
+	}
+}
+
+// removeBackupMeta removes the sidecar of backup, if there is one.
+func (l *Logger) removeBackupMeta(backup string) {
+	if err := os.Remove(metaFilename(backup)); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "timberjack: [%s] failed to remove metadata for %s: %v\n", l.Filename, backup, err)
+	}
+}
+
+// lineIndexFilename returns the line index path for a backup, compressed or not.
+func lineIndexFilename(backup string) string {
+	return strings.TrimSuffix(backup, compressSuffix) + indexSuffix
+}
+
+// buildLineIndexes writes a line index for every backup that doesn't have one yet.
+func (l *Logger) buildLineIndexes() {
+	files, err := l.oldLogFiles()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "timberjack: [%s] failed to list backups for indexing: %v\n", l.Filename, err)
+		return
+	}
+	for _, f := range files {
+		backup := filepath.Join(l.dir(), f.Name())
+		if _, err := os.Stat(lineIndexFilename(backup)); err == nil {
+			continue
+		}
+		if err := writeLineIndex(backup); err != nil {
+			fmt.Fprintf(os.Stderr, "timberjack: [%s] failed to index %s: %v\n", l.Filename, backup, err)
+		}
+	}
+}
+
+// writeLineIndex records the offset of every line of backup in its index file,
+// as a sequence of uvarint-encoded differences between consecutive offsets.
+func writeLineIndex(backup string) error {
+	r, err := OpenBackup(backup)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var index []byte
+	var varint [binary.MaxVarintLen64]byte
+	var pos, prev int64
+	atLineStart := true
+	buf := make([]byte, 32*1024)
+	for {
+		n, errRead := r.Read(buf)
+		for _, b := range buf[:n] {
+			if atLineStart {
+				index = append(index, varint[:binary.PutUvarint(varint[:], uint64(pos-prev))]...)
+				prev = pos
+				atLineStart = false
+			}
+			if b == '\n' {
+				atLineStart = true
+			}
+			pos++
+		}
+		if errRead == io.EOF {
+			break
+		}
+		if errRead != nil {
+			return errRead
+		}
+	}
+
+	tmp := lineIndexFilename(backup) + ".tmp"
+	if err := os.WriteFile(tmp, index, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, lineIndexFilename(backup))
+}
+
+// LineIndex returns the offsets at which the lines of the backup at path start,
+// in its uncompressed content: line i (counting from 0) starts at offset
+// index[i]. The index is built by cleanup when BuildLineIndex is set; if it
+// hasn't been built (yet), the error wraps os.ErrNotExist. Together with
+// OpenBackup, this allows seeking to a line without scanning the whole backup.
+func LineIndex(path string) ([]int64, error) {
+	data, err := os.ReadFile(lineIndexFilename(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read line index for %s: %w", path, err)
+	}
+	var index []int64
+	var offset int64
+	for len(data) > 0 {
+		delta, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("corrupt line index for %s", path)
+		}
+		offset += int64(delta)
+		index = append(index, offset)
+		data = data[n:]
+	}
+	return index, nil
+}
+
+// removeLineIndex removes the line index of backup, if there is one.
+func (l *Logger) removeLineIndex(backup string) {
+	if err := os.Remove(lineIndexFilename(backup)); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "timberjack: [%s] failed to remove line index for %s: %v\n", l.Filename, backup, err)
+	}
+}
+
+// loadState restores logStartTime and lastRotationTime from the state file, if
+// they aren't already known. A missing or unreadable state file is ignored.
+// It expects l.mu to be held.
+func (l *Logger) loadState() {
+	data, err := os.ReadFile(l.stateFilename())
+	if err != nil {
+		return
+	}
+	var state loggerState
+	if err := json.Unmarshal(data, &state); err != nil {
+		fmt.Fprintf(os.Stderr, "timberjack: [%s] ignoring malformed state file %s: %v\n", l.Filename, l.stateFilename(), err)
+		return
+	}
+	if l.logStartTime.IsZero() {
+		l.logStartTime = state.LogStartTime
+	}
+	if l.lastRotationTime.IsZero() && !state.LastRotationTime.IsZero() {
+		l.lastRotationTime = state.LastRotationTime.In(l.location())
+	}
+	l.persistedState = state
+}
+
+// persistState writes logStartTime and lastRotationTime to the state file if
+// PersistState is set and they changed since the last write. The file is
+// replaced atomically. It expects l.mu to be held.
+func (l *Logger) persistState() {
+	if !l.PersistState {
+		return
+	}
+	state := loggerState{LogStartTime: l.logStartTime, LastRotationTime: l.lastRotationTime}
+	if state.LogStartTime.Equal(l.persistedState.LogStartTime) && state.LastRotationTime.Equal(l.persistedState.LastRotationTime) {
+		return
+	}
+
+	data, err := json.Marshal(state)
+	if err == nil {
+		tmp := l.stateFilename() + ".tmp"
+		if err = os.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, l.stateFilename())
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "timberjack: [%s] failed to write state file %s: %v\n", l.Filename, l.stateFilename(), err)
+		return
+	}
+	l.persistedState = state
+}
+
+// startupReason returns the backup filename reason for RotateOnStart rotations.
+func (l *Logger) startupReason() string {
+	if l.StartupRotationReason != "" {
+		return l.StartupRotationReason
+	}
+	return "start"
+}
+
 // filename returns the current log filename, using the configured Filename,
 // or a default based on the process name if Filename is empty.
 func (l *Logger) filename() string {
@@ -663,13 +3344,159 @@ func (l *Logger) filename() string {
 // If compression is enabled, uncompressed backups are compressed using gzip.
 // Old backup files are deleted to enforce MaxBackups and MaxAge limits.
 func (l *Logger) millRunOnce() error {
-	if l.MaxBackups == 0 && l.MaxAge == 0 && !l.Compress {
-		return nil // Nothing to do if all cleanup options are disabled.
+	_, err := l.millRunLimited(0)
+	return err
+}
+
+// millRunLimited is millRunOnce doing at most limit removals and compressions
+// (no limit if limit <= 0). more reports whether work was left for a later run.
+// Passes are serialized by millMu, so two of them never compress the same backup.
+func (l *Logger) millRunLimited(limit int) (more bool, err error) {
+	l.millMu.Lock()
+	defer l.millMu.Unlock()
+	more, err = l.cleanup(l.Compress || len(l.CompressionByReason) > 0, limit)
+	if err != nil {
+		return false, err
+	}
+	if l.BuildLineIndex {
+		l.buildLineIndexes()
+	}
+	if l.DropCacheOnRotate {
+		l.dropPendingCaches()
+	}
+	if l.Uploader != nil {
+		l.uploadBackups(l.Compress || len(l.CompressionByReason) > 0)
+	}
+	return more, nil
+}
+
+// dropPendingCaches drops the page cache of the backups made since the last
+// run, unless cleanup has removed or compressed them in the meantime.
+func (l *Logger) dropPendingCaches() {
+	l.dropCacheMu.Lock()
+	pending := l.dropCachePending
+	l.dropCachePending = nil
+	l.dropCacheMu.Unlock()
+	for _, backup := range pending {
+		if _, err := osStat(backup); err == nil {
+			l.dropCache(backup)
+		}
+	}
+}
+
+// dropCache drops the page cache of the file at path. As this is only advice to
+// the kernel, a failure is reported to stderr only.
+func (l *Logger) dropCache(path string) {
+	if err := dropPageCache(path); err != nil {
+		fmt.Fprintf(os.Stderr, "timberjack: [%s] failed to drop page cache of %s: %v\n", l.Filename, path, err)
+	}
+}
+
+// uploadBackups passes every final backup not uploaded yet to Uploader and, with
+// RemoveAfterUpload, removes it once uploaded. compress tells whether backups
+// are compressed, in which case uncompressed ones that are to be compressed
+// aren't final yet.
+func (l *Logger) uploadBackups(compress bool) {
+	l.uploadMu.Lock()
+	defer l.uploadMu.Unlock()
+
+	files, err := l.oldLogFiles()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "timberjack: [%s] failed to list backups for upload: %v\n", l.Filename, err)
+		return
+	}
+	if len(l.uploaded) > 0 {
+		// Forget the backups that cleanup has removed since, so that the set
+		// doesn't grow for as long as the Logger lives.
+		current := make(map[string]bool, len(files))
+		for _, f := range files {
+			current[filepath.Join(l.dir(), f.Name())] = true
+		}
+		for backup := range l.uploaded {
+			if !current[backup] {
+				delete(l.uploaded, backup)
+			}
+		}
+	}
+	for i := len(files) - 1; i >= 0; i-- { // oldest first
+		name := files[i].Name()
+		if compress && !l.isCompressedName(name) && l.compressesReason(name) {
+			continue // Not compressed yet.
+		}
+		backup := filepath.Join(l.dir(), name)
+		if l.uploaded[backup] {
+			continue
+		}
+		err := l.Uploader.Upload(context.Background(), backup)
+		l.audit("upload", backup, "", err)
+		if err != nil {
+			err = fmt.Errorf("failed to upload %s: %w", backup, err)
+			fmt.Fprintf(os.Stderr, "timberjack: [%s] %v\n", l.Filename, err)
+			l.reportError(err)
+			continue
+		}
+		if !l.RemoveAfterUpload {
+			if l.uploaded == nil {
+				l.uploaded = make(map[string]bool)
+			}
+			l.uploaded[backup] = true
+			continue
+		}
+		errRemove := osRemove(backup)
+		l.audit("remove", backup, "uploaded", errRemove)
+		if errRemove != nil && !os.IsNotExist(errRemove) {
+			fmt.Fprintf(os.Stderr, "timberjack: [%s] failed to remove uploaded backup %s: %v\n", l.Filename, name, errRemove)
+		}
+		if l.WriteMetaSidecar {
+			l.removeBackupMeta(backup)
+		}
+		if l.BuildLineIndex {
+			l.removeLineIndex(backup)
+		}
+	}
+}
+
+// ApplyRetention removes the backups that the retention settings no longer
+// allow, right away and using the current configuration, instead of waiting for
+// the next rotation. The settings apply in the same order as in the cleanup
+// after a rotation: MaxBackups, MaxAge, ThinningPolicy, MaxTotalSize and then
+// MaxFilesInDir, each on the backups the previous ones kept. Unlike that
+// cleanup, it doesn't compress anything. It waits for a cleanup already running
+// in the background to finish. OnCleanup, if set, is called as usual.
+func (l *Logger) ApplyRetention() error {
+	l.millMu.Lock()
+	defer l.millMu.Unlock()
+	_, err := l.cleanup(false, 0)
+	return err
+}
+
+// Reprocess brings the existing backups in line with the current configuration,
+// right away instead of waiting for the next rotation: it runs the same pass as
+// the cleanup after a rotation, pruning as ApplyRetention does, compressing
+// backups that Compress or CompressionByReason now cover, and, with
+// BuildLineIndex, building missing line indexes. It waits for a cleanup already
+// running in the background to finish rather than running alongside it. Use it
+// after changing those settings on a running Logger.
+func (l *Logger) Reprocess() error {
+	return l.millRunOnce()
+}
+
+// cleanup enforces MaxBackups, MaxAge, ThinningPolicy, MaxTotalSize and
+// MaxFilesInDir, in that order, and, if compress is true, compresses the
+// remaining uncompressed backups. If limit > 0, it stops
+// after that many removals and compressions and reports through more that
+// files were left for later. It expects l.millMu to be held.
+func (l *Logger) cleanup(compress bool, limit int) (more bool, err error) {
+	if l.MaxBackups == 0 && l.MaxAge == 0 && !compress && l.MaxFilesInDir == 0 && l.MaxTotalSize == 0 && l.ThinningPolicy.KeepAllDays == 0 {
+		return false, nil // Nothing to do if all cleanup options are disabled.
+	}
+	if l.RingSize > 0 {
+		return false, nil // The ring bounds the backups by itself.
 	}
 
 	files, err := l.oldLogFiles() // Gets LogInfo structs, sorted newest first by timestamp
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	var filesToProcess = files  // Start with all found old log files
@@ -709,7 +3536,7 @@ func (l *Logger) millRunOnce() error {
 	// MaxAge filtering (operates on files that passed MaxBackups filter)
 	if l.MaxAge > 0 {
 		diff := time.Duration(int64(24*time.Hour) * int64(l.MaxAge))
-		cutoff := currentTime().Add(-1 * diff)
+		cutoff := l.clockNow().Add(-1 * diff)
 		var filteredFiles []logInfo // Files that pass this MaxAge filter
 		for _, f := range filesToProcess {
 			if f.timestamp.Before(cutoff) {
@@ -731,11 +3558,35 @@ func (l *Logger) millRunOnce() error {
 		filesToProcess = filteredFiles // Update filesToProcess for compression filter
 	}
 
+	// Thinning (operates on files that passed MaxBackups and MaxAge)
+	if l.ThinningPolicy.KeepAllDays > 0 {
+		var thinned []logInfo
+		filesToProcess, thinned = l.thin(filesToProcess)
+		filesToRemove = append(filesToRemove, thinned...)
+	}
+
+	// MaxTotalSize filtering (operates on files that passed MaxBackups and MaxAge)
+	if l.MaxTotalSize > 0 {
+		var removedBySize []logInfo
+		filesToProcess, removedBySize = l.capTotalSize(filesToProcess)
+		filesToRemove = append(filesToRemove, removedBySize...)
+	}
+
+	// MaxFilesInDir filtering (operates on files that passed MaxBackups and MaxAge)
+	if l.MaxFilesInDir > 0 {
+		var removedByCap []logInfo
+		filesToProcess, removedByCap = l.capFilesInDir(filesToProcess)
+		filesToRemove = append(filesToRemove, removedByCap...)
+	}
+
 	// Compression task identification (operates on files that passed MaxBackups and MaxAge)
 	var filesToCompress []logInfo
-	if l.Compress {
+	if compress {
 		for _, f := range filesToProcess { // These are files that are meant to be kept (not in filesToRemove yet)
-			if !strings.HasSuffix(f.Name(), compressSuffix) {
+			if l.CompressMinAge > 0 && l.clockNow().Sub(f.timestamp) < l.CompressMinAge {
+				continue // Too recent; readers may still be on the uncompressed file.
+			}
+			if !l.isCompressedName(f.Name()) && l.compressesReason(f.Name()) {
 				// Ensure this file isn't ALREADY marked for removal by a previous filter
 				// (e.g. MaxBackups removed it, but it also met MaxAge criteria before this loop)
 				// This check is somewhat redundant if filesToProcess is correctly filtered,
@@ -759,29 +3610,159 @@ func (l *Logger) millRunOnce() error {
 	for _, f := range filesToRemove {
 		finalUniqueRemovals[f.Name()] = f
 	}
-	for _, f := range finalUniqueRemovals {
-		errRemove := osRemove(filepath.Join(l.dir(), f.Name()))
+
+	// Apply the work limit, removals first, oldest files first.
+	var removalNames []string
+	for name := range finalUniqueRemovals {
+		removalNames = append(removalNames, name)
+	}
+	sort.Slice(removalNames, func(i, j int) bool {
+		return finalUniqueRemovals[removalNames[i]].timestamp.Before(finalUniqueRemovals[removalNames[j]].timestamp)
+	})
+	if limit > 0 {
+		if len(removalNames) > limit {
+			removalNames = removalNames[:limit]
+		}
+		more = len(removalNames) < len(finalUniqueRemovals)
+		if budget := limit - len(removalNames); len(filesToCompress) > budget {
+			filesToCompress = filesToCompress[len(filesToCompress)-budget:] // filesToCompress is newest first
+			more = true
+		}
+	}
+
+	var removed, compressed []string // Paths acted upon, reported to OnCleanup
+	for _, name := range removalNames {
+		f := finalUniqueRemovals[name]
+		fn := filepath.Join(l.dir(), f.Name())
+		errRemove := osRemove(fn)
 		if errRemove != nil && !os.IsNotExist(errRemove) { // Log error if removal failed and file wasn't already gone
 			fmt.Fprintf(os.Stderr, "timberjack: [%s] failed to remove old log file %s: %v\n", l.Filename, f.Name(), errRemove)
+			l.audit("remove", fn, "", errRemove)
+		} else if errRemove == nil {
+			removed = append(removed, fn)
+			l.audit("remove", fn, "", nil)
+		}
+		if l.WriteMetaSidecar {
+			l.removeBackupMeta(fn)
+		}
+		if l.BuildLineIndex {
+			l.removeLineIndex(fn)
 		}
 	}
+	sort.Strings(removed) // Map iteration order is random
 
 	// Execute compressions
 	for _, f := range filesToCompress {
 		fn := filepath.Join(l.dir(), f.Name())
-		errCompress := compressLogFile(fn, fn+compressSuffix) // fn is source, fn+compressSuffix is dest
+		var errCompress error
+		if hasGzipMagic(fn) {
+			// The content was written already gzipped; compressing it again would
+			// only waste CPU, so just give it the suffix.
+			errCompress = osRename(fn, fn+compressSuffix)
+		} else {
+			errCompress = compressLogFileWith(fn, fn+compressSuffix, l.compressOptions()) // fn is source, fn+compressSuffix is dest
+		}
+		l.audit("compress", fn, "", errCompress)
 		if errCompress != nil {
 			fmt.Fprintf(os.Stderr, "timberjack: [%s] failed to compress log file %s: %v\n", l.Filename, f.Name(), errCompress)
+			if errors.Is(errCompress, syscall.ENOSPC) {
+				// The disk is full, so the remaining files would fail the same way.
+				// Leave them uncompressed and try again on the next run.
+				l.reportError(fmt.Errorf("timberjack: compression stopped, no space left: %w", errCompress))
+				break
+			}
+		} else {
+			compressed = append(compressed, fn)
+			if l.WriteMetaSidecar {
+				l.markBackupMetaCompressed(fn)
+			}
+			if l.DropCacheOnRotate {
+				l.dropCache(fn + compressSuffix)
+			}
 		}
 	}
-	return nil
+
+	if l.OnCleanup != nil {
+		l.OnCleanup(removed, compressed)
+	}
+	return more, nil
 }
 
 // millRun runs in a goroutine to manage post-rotation compression and removal
-// of old log files. It listens on millCh for signals to run millRunOnce.
-func (l *Logger) millRun() {
-	for range l.millCh { // Loop terminates when millCh is closed
-		_ = l.millRunOnce()
+// of old log files. It listens on ch, the Logger's millCh, for signals to run
+// millRunOnce. ch is passed in because Close clears millCh.
+func (l *Logger) millRun(ch chan bool) {
+	defer l.millWg.Done()
+	for range ch { // Loop terminates when millCh is closed
+		more, err := l.millRunLimited(l.MaxMillWorkPerRun)
+		if err == nil {
+			atomic.StoreInt64(&l.stats.millConsecutiveErrors, 0)
+			l.recordSuccess()
+			if more {
+				// Signal ourselves to pick up the rest of the backlog. mill() takes
+				// care of a concurrent Close.
+				l.mu.Lock()
+				l.mill()
+				l.mu.Unlock()
+			}
+			continue
+		}
+		l.recordFailure(fmt.Sprintf("cleanup failed: %v", err))
+
+		atomic.AddInt64(&l.stats.millErrors, 1)
+		consecutive := atomic.AddInt64(&l.stats.millConsecutiveErrors, 1)
+		l.reportError(err)
+		if l.MillMaxConsecutiveErrors > 0 && consecutive >= int64(l.MillMaxConsecutiveErrors) {
+			// Give up; mill() never blocks on the now-undrained channel.
+			atomic.StoreUint32(&l.stats.millStopped, 1)
+			l.reportError(fmt.Errorf("%w after %d consecutive failures (last: %v)", ErrMillStopped, consecutive, err))
+			return
+		}
+	}
+}
+
+// recordFailure counts a failed rotation or cleanup run and reports the Logger
+// as unhealthy through OnStateChange once UnhealthyAfterFailures is reached.
+func (l *Logger) recordFailure(reason string) {
+	if l.OnStateChange == nil {
+		return
+	}
+	threshold := l.UnhealthyAfterFailures
+	if threshold <= 0 {
+		threshold = 1
+	}
+	l.healthMu.Lock()
+	l.healthFailures++
+	changed := !l.unhealthy && l.healthFailures >= threshold
+	if changed {
+		l.unhealthy = true
+	}
+	l.healthMu.Unlock()
+	if changed {
+		l.OnStateChange(false, reason)
+	}
+}
+
+// recordSuccess resets the failure count and reports recovery through
+// OnStateChange if the Logger was unhealthy.
+func (l *Logger) recordSuccess() {
+	if l.OnStateChange == nil {
+		return
+	}
+	l.healthMu.Lock()
+	l.healthFailures = 0
+	changed := l.unhealthy
+	l.unhealthy = false
+	l.healthMu.Unlock()
+	if changed {
+		l.OnStateChange(true, "")
+	}
+}
+
+// reportError passes err to OnRotationError, if set.
+func (l *Logger) reportError(err error) {
+	if l.OnRotationError != nil {
+		l.OnRotationError(err)
 	}
 }
 
@@ -791,32 +3772,48 @@ func (l *Logger) mill() {
 	if atomic.LoadUint32(&l.isClosed) == 1 {
 		return // Don't run if logger is closed
 	}
-	l.startMill.Do(func() {
-		l.millCh = make(chan bool, 1) // Buffered channel of 1
-		go l.millRun()
-	})
+	l.ensureMillRunning()
 	select {
 	case l.millCh <- true: // Send signal to run millRunOnce
 	default: // Don't block if channel is full (mill is already busy)
 	}
 }
 
+// ensureMillRunning starts the mill goroutine if it is not already running.
+// It expects l.mu to be held.
+func (l *Logger) ensureMillRunning() {
+	l.startMill.Do(func() {
+		// Settle BackupTimeFormat before the goroutine first reads it.
+		l.validateBackupTimeFormatOnce()
+		l.millCh = make(chan bool, 1) // Buffered channel of 1
+		l.millWg.Add(1)
+		go l.millRun(l.millCh)
+	})
+}
+
 // oldLogFiles returns the list of backup log files stored in the same
 // directory as the current log file, sorted by their embedded timestamp (newest first).
 func (l *Logger) oldLogFiles() ([]logInfo, error) {
-	entries, err := os.ReadDir(l.dir()) // ReadDir is generally preferred over ReadFile for directory listings
+	entries, err := osReadDir(l.dir()) // ReadDir is generally preferred over ReadFile for directory listings
 	if err != nil {
 		return nil, fmt.Errorf("can't read log file directory: %s", err)
 	}
 	var logFiles []logInfo
 
 	prefix, ext := l.prefixAndExt() // Get prefix like "filename-" and original extension like ".log"
+	layouts := l.backupLayouts()    // Resolved once rather than for every entry
 
 	for _, e := range entries {
 		if e.IsDir() { // Skip directories
 			continue
 		}
 		name := e.Name()
+		if strings.HasSuffix(name, metaSuffix) || strings.HasSuffix(name, indexSuffix) {
+			continue // Metadata sidecars and line indexes are not backups themselves
+		}
+		if l.isAuditFile(name) {
+			continue
+		}
 		info, errInfo := e.Info() // Get FileInfo for modification time and other details
 		if errInfo != nil {
 			// fmt.Fprintf(os.Stderr, "timberjack: failed to get FileInfo for %s: %v\n", name, errInfo)
@@ -824,13 +3821,20 @@ func (l *Logger) oldLogFiles() ([]logInfo, error) {
 		}
 
 		// Attempt to parse timestamp from filename (e.g., from "filename-timestamp-reason.log")
-		if t, errTime := l.timeFromName(name, prefix, ext); errTime == nil {
+		if t, errTime := l.timeFromName(name, prefix, ext, layouts); errTime == nil {
 			logFiles = append(logFiles, logInfo{t, info})
 			continue
 		}
 		// Attempt to parse timestamp from compressed filename (e.g., from "filename-timestamp-reason.log.gz")
-		if t, errTime := l.timeFromName(name, prefix, ext+compressSuffix); errTime == nil {
-			logFiles = append(logFiles, logInfo{t, info})
+		matched := false
+		for _, compressed := range l.compressedExts() {
+			if t, errTime := l.timeFromName(name, prefix, ext+compressed, layouts); errTime == nil {
+				logFiles = append(logFiles, logInfo{t, info})
+				matched = true
+				break
+			}
+		}
+		if matched {
 			continue
 		}
 		// Files that don't match the expected backup pattern are ignored.
@@ -840,9 +3844,190 @@ func (l *Logger) oldLogFiles() ([]logInfo, error) {
 	return logFiles, nil
 }
 
+// BackupInfo describes a backup log file produced by rotation.
+type BackupInfo struct {
+	Path       string    // Full path of the backup file
+	Timestamp  time.Time // Rotation time encoded in the filename
+	Size       int64     // Size of the file on disk, in bytes
+	Compressed bool      // Whether the backup has been compressed
+}
+
+// Backups returns the backup files that belong to this logger, sorted by their
+// rotation timestamp, newest first. Files in the log directory that don't match
+// the backup naming pattern are ignored.
+func (l *Logger) Backups() ([]BackupInfo, error) {
+	return l.BackupsSorted(NewestFirst)
+}
+
+// SortOrder is the order in which BackupsSorted returns backups.
+type SortOrder int
+
+const (
+	// NewestFirst sorts backups by rotation timestamp, newest first, like Backups.
+	NewestFirst SortOrder = iota
+	// OldestFirst sorts backups by rotation timestamp, oldest first.
+	OldestFirst
+)
+
+// BackupsSorted is like Backups, but returns the backups in the given order.
+func (l *Logger) BackupsSorted(order SortOrder) ([]BackupInfo, error) {
+	if order != NewestFirst && order != OldestFirst {
+		return nil, fmt.Errorf("timberjack: unknown SortOrder %d", order)
+	}
+	files, err := l.oldLogFiles() // newest first
+	if err != nil {
+		return nil, err
+	}
+	if order == OldestFirst {
+		sort.Sort(sort.Reverse(byFormatTime(files)))
+	}
+	backups := make([]BackupInfo, 0, len(files))
+	for _, f := range files {
+		backups = append(backups, BackupInfo{
+			Path:       filepath.Join(l.dir(), f.Name()),
+			Timestamp:  f.timestamp,
+			Size:       f.Size(),
+			Compressed: l.isCompressedName(f.Name()),
+		})
+	}
+	return backups, nil
+}
+
+// BackupsSince returns the backups whose rotation timestamp is strictly after t,
+// newest first. A backup whose timestamp equals t is excluded, so an incremental
+// consumer can pass the Timestamp of the newest backup it has already processed
+// as its high-water mark. Note that timestamps only carry the precision of
+// BackupTimeFormat.
+func (l *Logger) BackupsSince(t time.Time) ([]BackupInfo, error) {
+	backups, err := l.Backups()
+	if err != nil {
+		return nil, err
+	}
+	// Backups are sorted newest first, so stop at the first one not after t.
+	for i, b := range backups {
+		if !b.Timestamp.After(t) {
+			return backups[:i], nil
+		}
+	}
+	return backups, nil
+}
+
+// DiskUsage returns the space, in bytes, taken by the active log file and by the
+// backups, the latter including their metadata sidecars (WriteMetaSidecar) and
+// line indexes (BuildLineIndex). total is the sum of the two. Writes still held
+// in the BufferSize buffer are not counted.
+func (l *Logger) DiskUsage() (activeBytes, backupBytes, total int64, err error) {
+	if info, errStat := osStat(l.filename()); errStat == nil {
+		activeBytes = info.Size()
+	} else if !os.IsNotExist(errStat) {
+		return 0, 0, 0, errStat
+	}
+
+	files, err := l.oldLogFiles()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	sidecars := make(map[string]bool)
+	for _, f := range files {
+		backupBytes += f.Size()
+		backup := filepath.Join(l.dir(), f.Name())
+		sidecars[metaFilename(backup)] = true
+		sidecars[lineIndexFilename(backup)] = true
+	}
+	for sidecar := range sidecars {
+		if info, errStat := osStat(sidecar); errStat == nil {
+			backupBytes += info.Size()
+		}
+	}
+	return activeBytes, backupBytes, activeBytes + backupBytes, nil
+}
+
+// PurgeBackups removes every backup file that belongs to this logger, compressed
+// or not, regardless of MaxBackups and MaxAge. The active log file is left intact.
+// It returns the number of files removed. If some files can't be removed, the
+// remaining ones are still attempted and the first error is returned.
+func (l *Logger) PurgeBackups() (removed int, err error) {
+	files, err := l.oldLogFiles()
+	if err != nil {
+		return 0, err
+	}
+	for _, f := range files {
+		fn := filepath.Join(l.dir(), f.Name())
+		errRemove := osRemove(fn)
+		if l.WriteMetaSidecar {
+			l.removeBackupMeta(fn)
+		}
+		if l.BuildLineIndex {
+			l.removeLineIndex(fn)
+		}
+		if errRemove == nil {
+			removed++
+		} else if err == nil && !os.IsNotExist(errRemove) {
+			err = fmt.Errorf("failed to remove backup %s: %w", f.Name(), errRemove)
+		}
+	}
+	return removed, err
+}
+
+// compressesReason reports whether the uncompressed backup with the given name
+// is to be compressed, according to CompressionByReason for the reason in its
+// name, or else Compress.
+func (l *Logger) compressesReason(name string) bool {
+	prefix, ext := l.prefixAndExt()
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) || len(name) < len(prefix)+len(ext) {
+		return l.Compress
+	}
+	trimmed := name[len(prefix) : len(name)-len(ext)]
+	reason := trimmed[strings.LastIndex(trimmed, "-")+1:]
+	switch format, ok := l.CompressionByReason[reason]; {
+	case !ok:
+		return l.Compress
+	case format == "none":
+		return false
+	case format == "gzip":
+		return true
+	default:
+		l.compressionFormatWarnOnce.Do(l.warnUnsupportedCompressionFormats)
+		return l.Compress
+	}
+}
+
+// warnUnsupportedCompressionFormats reports every CompressionByReason entry with
+// an unsupported format to stderr.
+func (l *Logger) warnUnsupportedCompressionFormats() {
+	var reasons []string
+	for reason, format := range l.CompressionByReason {
+		if format != "gzip" && format != "none" {
+			reasons = append(reasons, reason)
+		}
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(os.Stderr, "timberjack: [%s] unsupported compression format %q for reason %q, using Compress\n", l.Filename, l.CompressionByReason[reason], reason)
+	}
+}
+
+// compressedExts returns the suffixes of compressed backups: ".gz" and any
+// RecognizedCompressedExts.
+func (l *Logger) compressedExts() []string {
+	return append([]string{compressSuffix}, l.RecognizedCompressedExts...)
+}
+
+// isCompressedName reports whether a backup filename has a compressed suffix.
+func (l *Logger) isCompressedName(name string) bool {
+	for _, compressed := range l.compressedExts() {
+		if strings.HasSuffix(name, compressed) {
+			return true
+		}
+	}
+	return false
+}
+
 // timeFromName extracts the formatted timestamp from the backup filename.
-// It expects filenames like "prefix-YYYY-MM-DDTHH-MM-SS.mmm-reason.ext" or "...ext.gz".
-func (l *Logger) timeFromName(filename, prefix, ext string) (time.Time, error) {
+// It expects filenames like "prefix-YYYY-MM-DDTHH-MM-SS.mmm-reason.ext" or "...ext.gz",
+// or the same without the "-reason" segment (see OmitReasonInName). The timestamp
+// is parsed with each of layouts in turn, as returned by backupLayouts.
+func (l *Logger) timeFromName(filename, prefix, ext string, layouts []string) (time.Time, error) {
 	if !strings.HasPrefix(filename, prefix) {
 		return time.Time{}, errors.New("mismatched prefix")
 	}
@@ -859,19 +4044,50 @@ func (l *Logger) timeFromName(filename, prefix, ext string) (time.Time, error) {
 		return time.Time{}, fmt.Errorf("malformed backup filename: missing reason separator in '%s'", trimmed)
 	}
 
-	timestampPart := trimmed[:lastHyphenIdx]
-
 	// Determine location (UTC or Local) based on Logger's LocalTime setting for parsing.
 	currentLoc := time.UTC
 	if l.LocalTime {
 		currentLoc = time.Local
 	}
 
-	layout := l.BackupTimeFormat
-	if layout == "" {
-		layout = backupTimeFormat
+	t, err := parseBackupTime(trimmed[:lastHyphenIdx], currentLoc, layouts)
+	if err == nil {
+		return t, nil
+	}
+	// Backups named without a reason are all timestamp.
+	if t, errWhole := parseBackupTime(trimmed, currentLoc, layouts); errWhole == nil {
+		return t, nil
+	}
+	return time.Time{}, err
+}
+
+// parseBackupTime parses a backup timestamp with each of layouts in turn. The
+// error is that of the first layout.
+func parseBackupTime(value string, loc *time.Location, layouts []string) (time.Time, error) {
+	var firstErr error
+	for _, layout := range layouts {
+		t, err := time.ParseInLocation(layout, value, loc)
+		if err == nil {
+			return t, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return time.Time{}, firstErr
+}
+
+// backupLayouts returns the time layouts backup names are parsed with: the one
+// backups are named with, then PreviousBackupTimeFormats. The former is
+// BackupTimeFormat, or the default format if it is empty or invalid, exactly as
+// the first rotation settles it. Parsing with it even before that rotation keeps
+// the names timberjack writes and the names cleanup recognizes in agreement.
+func (l *Logger) backupLayouts() []string {
+	layout := backupTimeFormat
+	if l.ValidateBackupTimeFormat() == nil {
+		layout = l.BackupTimeFormat
 	}
-	return time.ParseInLocation(layout, timestampPart, currentLoc)
+	return append([]string{layout}, l.PreviousBackupTimeFormats...)
 }
 
 // max returns the maximum size in bytes of log files before rolling.
@@ -921,6 +4137,27 @@ func countDigitsAfterDot(layout string) int {
 	return 0 // no '.' found or no digits after dot
 }
 
+// fractionalSecondsElement returns the fractional-seconds element of a time
+// layout, such as ".000" or ",999999", or "" if it has none. Like the time
+// package, it only accepts a '.' or ',' followed by a run of '0's or '9's that
+// isn't followed by another digit.
+func fractionalSecondsElement(layout string) string {
+	for i := 0; i+1 < len(layout); i++ {
+		if (layout[i] != '.' && layout[i] != ',') || (layout[i+1] != '0' && layout[i+1] != '9') {
+			continue
+		}
+		j := i + 1
+		for j < len(layout) && layout[j] == layout[i+1] {
+			j++
+		}
+		if j < len(layout) && '0' <= layout[j] && layout[j] <= '9' {
+			continue
+		}
+		return layout[i:j]
+	}
+	return ""
+}
+
 // truncateFractional truncates time t to n fractional digits of seconds.
 // n=0 → truncate to seconds, n=3 → milliseconds, n=6 → microseconds, etc.
 func truncateFractional(t time.Time, n int) (time.Time, error) {
@@ -942,9 +4179,138 @@ func truncateFractional(t time.Time, n int) (time.Time, error) {
 	), nil
 }
 
+// thin applies ThinningPolicy to files, sorted newest first: of the backups
+// older than KeepAllDays, it keeps only those with the newest timestamp of their
+// calendar day. It returns the files kept and the files to remove.
+func (l *Logger) thin(files []logInfo) (kept, removed []logInfo) {
+	cutoff := l.clockNow().Add(-time.Duration(l.ThinningPolicy.KeepAllDays) * 24 * time.Hour)
+	newestOfDay := make(map[string]time.Time)
+	for _, f := range files {
+		if !f.timestamp.Before(cutoff) {
+			kept = append(kept, f)
+			continue
+		}
+		day := f.timestamp.In(l.location()).Format("2006-01-02")
+		newest, seen := newestOfDay[day]
+		if !seen {
+			newestOfDay[day] = f.timestamp
+			newest = f.timestamp
+		}
+		if f.timestamp.Equal(newest) {
+			kept = append(kept, f)
+		} else {
+			removed = append(removed, f)
+		}
+	}
+	return kept, removed
+}
+
+// capTotalSize splits kept, the backups to keep (sorted newest first), so that
+// their sizes on disk add up to at most MaxTotalSize, always keeping the newest
+// one. It returns the backups still kept and the oldest ones that must be removed.
+func (l *Logger) capTotalSize(kept []logInfo) (remaining, removed []logInfo) {
+	var total int64
+	for i, f := range kept {
+		total += f.Size()
+		if i > 0 && total > l.MaxTotalSize {
+			return kept[:i], kept[i:]
+		}
+	}
+	return kept, nil
+}
+
+// capFilesInDir splits kept, the backups to keep (sorted newest first), so that
+// together with the active file, the state file (PersistState) and the sidecars
+// (WriteMetaSidecar and BuildLineIndex) at most MaxFilesInDir managed files
+// remain. It returns the backups still kept and the oldest ones that must be
+// removed.
+func (l *Logger) capFilesInDir(kept []logInfo) (remaining, removed []logInfo) {
+	count := 1 + len(kept) // the active file and the backups
+	if l.PersistState {
+		count++
+	}
+	sidecarUsers := make(map[string]int) // sidecar path -> kept backups sharing it
+	for _, f := range kept {
+		for _, sidecar := range l.sidecars(filepath.Join(l.dir(), f.Name())) {
+			if sidecarUsers[sidecar] == 0 {
+				if _, err := os.Stat(sidecar); err != nil {
+					continue
+				}
+				count++
+			}
+			sidecarUsers[sidecar]++
+		}
+	}
+
+	n := len(kept)
+	for n > 0 && count > l.MaxFilesInDir {
+		n-- // kept is sorted newest first, so the oldest is last
+		count--
+		for _, sidecar := range l.sidecars(filepath.Join(l.dir(), kept[n].Name())) {
+			if sidecarUsers[sidecar] > 0 {
+				sidecarUsers[sidecar]--
+				if sidecarUsers[sidecar] == 0 {
+					count-- // removed together with its last backup
+				}
+			}
+		}
+	}
+	return kept[:n], kept[n:]
+}
+
+// sidecars returns the paths of the files that cleanup removes together with
+// backup: its metadata sidecar with WriteMetaSidecar and its line index with
+// BuildLineIndex.
+func (l *Logger) sidecars(backup string) []string {
+	var paths []string
+	if l.WriteMetaSidecar {
+		paths = append(paths, metaFilename(backup))
+	}
+	if l.BuildLineIndex {
+		paths = append(paths, lineIndexFilename(backup))
+	}
+	return paths
+}
+
+// compressOptions tunes how compressLogFileWith compresses a file.
+type compressOptions struct {
+	bufferSize      int  // size of the copy buffer; 0 uses io.Copy's default
+	preserveModTime bool // give the compressed file the source file's mtime
+	level           int  // gzip compression level; 0 uses gzip.DefaultCompression
+}
+
+// compressOptions returns the compression options configured on the Logger.
+func (l *Logger) compressOptions() compressOptions {
+	return compressOptions{
+		bufferSize:      l.CompressBufferSize,
+		preserveModTime: l.PreserveModTime,
+		level:           l.compressLevel(),
+	}
+}
+
+// compressLevel returns CompressLevel if it is a valid gzip level, or
+// gzip.DefaultCompression otherwise, warning once about an invalid level.
+func (l *Logger) compressLevel() int {
+	switch {
+	case l.CompressLevel == 0:
+		return gzip.DefaultCompression
+	case l.CompressLevel >= gzip.BestSpeed && l.CompressLevel <= gzip.BestCompression:
+		return l.CompressLevel
+	}
+	l.compressLevelWarnOnce.Do(func() {
+		fmt.Fprintf(os.Stderr, "timberjack: invalid CompressLevel %d — falling back to default compression\n", l.CompressLevel)
+	})
+	return gzip.DefaultCompression
+}
+
 // compressLogFile compresses the given source log file (src) to a destination file (dst),
 // removing the source file if compression is successful.
 func compressLogFile(src, dst string) error {
+	return compressLogFileWith(src, dst, compressOptions{})
+}
+
+// compressLogFileWith is like compressLogFile, using the given options.
+func compressLogFileWith(src, dst string, opts compressOptions) error {
 	srcFile, err := os.Open(src)
 	if err != nil {
 		return fmt.Errorf("failed to open source log file %s for compression: %v", src, err)
@@ -957,16 +4323,32 @@ func compressLogFile(src, dst string) error {
 	}
 
 	// Create or open the destination file for writing the compressed content
-	dstFile, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, srcInfo.Mode())
+	dstFile, err := osOpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, srcInfo.Mode())
 	if err != nil {
 		return fmt.Errorf("failed to open destination compressed log file %s: %v", dst, err)
 	}
 	// No `defer dstFile.Close()` here, explicit closing in sequence is critical.
 
-	gzWriter := gzip.NewWriter(dstFile)
+	level := opts.level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	gzWriter, err := gzip.NewWriterLevel(dstFile, level)
+	if err != nil {
+		_ = dstFile.Close()
+		_ = osRemove(dst)
+		return fmt.Errorf("failed to create gzip writer for %s: %w", dst, err)
+	}
 
 	// Copy data from source file to gzip writer
-	if _, err = io.Copy(gzWriter, srcFile); err != nil {
+	var reader io.Reader = srcFile
+	var buf []byte
+	if opts.bufferSize > 0 {
+		// Hide srcFile's WriteTo method, which would bypass our buffer.
+		reader = struct{ io.Reader }{srcFile}
+		buf = make([]byte, opts.bufferSize)
+	}
+	if _, err = io.CopyBuffer(gzWriter, reader, buf); err != nil {
 		// Error during copy. Attempt to clean up.
 		_ = gzWriter.Close() // Try to close gzip writer
 		_ = dstFile.Close()  // Try to close destination file
@@ -988,6 +4370,10 @@ func compressLogFile(src, dst string) error {
 		// Data is likely written and gzWriter closed successfully, but closing the file descriptor failed.
 		// The destination file might still be valid on disk. We typically wouldn't remove dst here
 		// as the data might be recoverable or fully written despite the close error.
+		// A full disk is the exception: the data was not written, so don't leave a truncated archive.
+		if errors.Is(err, syscall.ENOSPC) {
+			_ = osRemove(dst)
+		}
 		return fmt.Errorf("failed to close destination compressed file %s: %w", dst, err)
 	}
 
@@ -1003,6 +4389,15 @@ func compressLogFile(src, dst string) error {
 		// For now, it's logged, and compression proceeds to remove the source.
 	}
 
+	if opts.preserveModTime {
+		mtime := srcInfo.ModTime()
+		if errTimes := os.Chtimes(dst, mtime, mtime); errTimes != nil {
+			// Like chown, not fatal: the compressed file is valid.
+			fmt.Fprintf(os.Stderr, "timberjack: [%s] failed to set mtime of compressed log file %s: %v\n",
+				filepath.Base(src), dst, errTimes)
+		}
+	}
+
 	// Finally, after successful compression and closing (and optional chown), remove the original source file.
 	if err = osRemove(src); err != nil {
 		// This is a more significant error if the original isn't removed, as it might be re-processed.
@@ -1012,6 +4407,222 @@ func compressLogFile(src, dst string) error {
 	return nil // Compression successful
 }
 
+// renameOrCopy moves src to dst like osRename, but if they are on different
+// filesystems (EXDEV), e.g. for RotateTo, it copies src to dst, keeping its mode
+// and owner, and then removes src.
+func renameOrCopy(src, dst string) error {
+	err := osRename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return osRemove(src)
+}
+
+// copyFile copies src to dst with src's mode and owner. A partial dst is removed.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := osOpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if errClose := out.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		_ = osRemove(dst)
+		return err
+	}
+	// The mode given to OpenFile is subject to the umask; and like the mode,
+	// a failure to keep the owner isn't fatal.
+	_ = os.Chmod(dst, info.Mode())
+	_ = chown(dst, info)
+	return nil
+}
+
+// hasGzipMagic reports whether the file at path starts with the gzip magic bytes.
+func hasGzipMagic(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var magic [2]byte
+	if _, err := io.ReadFull(f, magic[:]); err != nil {
+		return false
+	}
+	return magic[0] == 0x1f && magic[1] == 0x8b
+}
+
+// OpenBackup opens the backup log file at path for reading. If path ends with the
+// compressed suffix (".gz"), the returned reader transparently decompresses the
+// content, so callers don't need to branch on the file extension.
+// Closing the returned ReadCloser also closes the underlying file.
+func OpenBackup(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup %s: %w", path, err)
+	}
+	if !strings.HasSuffix(path, compressSuffix) {
+		return f, nil
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("backup %s is not a valid gzip file: %w", path, err)
+	}
+	return &gzipReadCloser{Reader: gz, file: f}, nil
+}
+
+// gzipReadCloser is a gzip.Reader that also closes the file it reads from.
+type gzipReadCloser struct {
+	*gzip.Reader
+	file *os.File
+}
+
+// Close closes both the gzip reader and the underlying file.
+func (r *gzipReadCloser) Close() error {
+	gzErr := r.Reader.Close()
+	fileErr := r.file.Close()
+	if gzErr != nil {
+		return gzErr
+	}
+	return fileErr
+}
+
+// Stats is a snapshot of a Logger's activity counters, as returned by Logger.Stats.
+type Stats struct {
+	// MillErrors is the total number of failed cleanup runs.
+	MillErrors int64
+	// MillConsecutiveErrors is the number of cleanup runs that have failed in a row.
+	MillConsecutiveErrors int64
+	// MillStopped reports whether cleanup was stopped by MillMaxConsecutiveErrors.
+	MillStopped bool
+	// WriteLatency covers the file writes made by Write. Only kept with TrackLatency.
+	WriteLatency LatencyStats
+	// RotationLatency covers whole rotations, including cleanup being scheduled
+	// and OnRotate. Only kept with TrackLatency.
+	RotationLatency LatencyStats
+}
+
+// latencyBuckets is the number of buckets in a LatencyStats histogram.
+const latencyBuckets = 24
+
+// LatencyStats is a histogram of operation latencies. Buckets[0] counts
+// operations that took less than 1µs, Buckets[i] those that took from 2^(i-1)µs
+// up to 2^iµs, and the last bucket everything slower.
+type LatencyStats struct {
+	Count   int64
+	Total   time.Duration
+	Max     time.Duration
+	Buckets [latencyBuckets]int64
+}
+
+// Mean returns the average latency, or 0 if nothing was recorded.
+func (s LatencyStats) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+// Percentile returns an upper bound on the latency of the q-th quantile
+// (0 < q <= 1) of the recorded operations: the upper edge of the bucket it falls
+// in, capped at Max. It returns 0 if nothing was recorded.
+func (s LatencyStats) Percentile(q float64) time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(s.Count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, c := range s.Buckets {
+		seen += c
+		if seen >= rank && i < latencyBuckets-1 {
+			if bound := time.Duration(1<<uint(i)) * time.Microsecond; bound < s.Max {
+				return bound
+			}
+			break
+		}
+	}
+	return s.Max
+}
+
+// latencyHistogram holds the live counters behind a LatencyStats. All fields
+// are accessed atomically.
+type latencyHistogram struct {
+	count   int64
+	total   int64
+	max     int64
+	buckets [latencyBuckets]int64
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+	for us := d / time.Microsecond; us > 0 && i < latencyBuckets-1; us >>= 1 {
+		i++
+	}
+	atomic.AddInt64(&h.buckets[i], 1)
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.total, int64(d))
+	for {
+		cur := atomic.LoadInt64(&h.max)
+		if int64(d) <= cur || atomic.CompareAndSwapInt64(&h.max, cur, int64(d)) {
+			break
+		}
+	}
+}
+
+func (h *latencyHistogram) snapshot() LatencyStats {
+	s := LatencyStats{
+		Count: atomic.LoadInt64(&h.count),
+		Total: time.Duration(atomic.LoadInt64(&h.total)),
+		Max:   time.Duration(atomic.LoadInt64(&h.max)),
+	}
+	for i := range h.buckets {
+		s.Buckets[i] = atomic.LoadInt64(&h.buckets[i])
+	}
+	return s
+}
+
+// loggerStats holds the live counters behind Stats. All fields are accessed atomically.
+type loggerStats struct {
+	millErrors            int64
+	millConsecutiveErrors int64
+	writeLatency          latencyHistogram
+	rotationLatency       latencyHistogram
+	millStopped           uint32
+}
+
+// Stats returns a snapshot of the logger's activity counters. It only reads
+// atomic counters and never takes the logger's lock, so it doesn't block on, or
+// slow down, concurrent writes and can be scraped as often as needed. The
+// fields are read one by one, so a snapshot taken during a write may be
+// slightly inconsistent between fields.
+func (l *Logger) Stats() Stats {
+	return Stats{
+		MillErrors:            atomic.LoadInt64(&l.stats.millErrors),
+		MillConsecutiveErrors: atomic.LoadInt64(&l.stats.millConsecutiveErrors),
+		MillStopped:           atomic.LoadUint32(&l.stats.millStopped) == 1,
+		WriteLatency:          l.stats.writeLatency.snapshot(),
+		RotationLatency:       l.stats.rotationLatency.snapshot(),
+	}
+}
+
 // logInfo is a convenience struct to return the filename and its embedded
 // timestamp, along with its os.FileInfo.
 type logInfo struct {
@@ -3,14 +3,23 @@ package timberjack
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -28,10 +37,23 @@ import (
 // that doesn't change unless we want it to.
 var fakeCurrentTime = time.Now()
 
+// fakeTimeMu guards fakeCurrentTime, which background goroutines may read while
+// a test moves it on with newFakeTime or setFakeTime.
+var fakeTimeMu sync.Mutex
+
 func fakeTime() time.Time {
+	fakeTimeMu.Lock()
+	defer fakeTimeMu.Unlock()
 	return fakeCurrentTime
 }
 
+// setFakeTime sets the fake "current time" to t.
+func setFakeTime(t time.Time) {
+	fakeTimeMu.Lock()
+	defer fakeTimeMu.Unlock()
+	fakeCurrentTime = t
+}
+
 func TestNewFile(t *testing.T) {
 	currentTime = fakeTime
 
@@ -96,6 +118,126 @@ func TestWriteTooLong(t *testing.T) {
 	assert(os.IsNotExist(err), t, "File exists, but should not have been created")
 }
 
+func TestWriteString(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+
+	// The same writes, through Write and WriteString, give the same files.
+	lines := []string{"boo!", "foooooo!", "bar", "baz!!!!", "qux"}
+	run := func(writeString bool) map[string]string {
+		dir := t.TempDir()
+		l := &Logger{Filename: logFile(dir), MaxSize: 10}
+		defer l.Close()
+		for _, line := range lines {
+			var n int
+			var err error
+			if writeString {
+				n, err = l.WriteString(line)
+			} else {
+				n, err = l.Write([]byte(line))
+			}
+			isNil(err, t)
+			equals(len(line), n, t)
+			newFakeTime()
+		}
+		files := map[string]string{}
+		entries, err := os.ReadDir(dir)
+		isNil(err, t)
+		for _, e := range entries {
+			b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+			isNil(err, t)
+			files[e.Name()] = string(b)
+		}
+		return files
+	}
+	start := fakeCurrentTime
+	viaWrite := run(false)
+	setFakeTime(start)
+	viaWriteString := run(true)
+	equals(4, len(viaWrite), t) // three size rotations
+	equals(viaWrite, viaWriteString, t)
+}
+
+func TestWriteMulti(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := t.TempDir()
+	l := &Logger{Filename: logFile(dir), MaxSize: 10}
+	defer l.Close()
+
+	n, err := l.WriteMulti([]byte("boo"), nil, []byte("!"))
+	isNil(err, t)
+	equals(4, n, t)
+
+	// The record rotates as a whole rather than being split across files.
+	n, err = l.WriteMulti([]byte("fooo"), []byte("ooo!"))
+	isNil(err, t)
+	equals(8, n, t)
+	existsWithContent(logFile(dir), []byte("foooooo!"), t)
+	existsWithContent(backupFileWithReason(dir, "size"), []byte("boo!"), t)
+
+	// MaxSize applies to the total.
+	_, err = l.WriteMulti([]byte("123456"), []byte("789012"))
+	notNil(err, t)
+}
+
+func TestWriteMultiConcurrent(t *testing.T) {
+	megabyte = 1
+	dir := t.TempDir()
+	filename := logFile(dir)
+	l := &Logger{Filename: filename, MaxSize: 1 << 20}
+	defer l.Close()
+
+	const writers, records = 8, 200
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < records; i++ {
+				id := []byte(fmt.Sprintf("w%d-r%d", w, i))
+				_, err := l.WriteMulti([]byte("begin "), id, []byte(" middle "), id, []byte(" end\n"))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(filename)
+	isNil(err, t)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	equals(writers*records, len(lines), t)
+	for _, line := range lines {
+		var a, b string
+		_, err := fmt.Sscanf(line, "begin %s middle %s end", &a, &b)
+		isNil(err, t)
+		equals(a, b, t)
+	}
+}
+
+func TestWriteStringTooLongAndClosed(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := t.TempDir()
+	filename := logFile(dir)
+	l := &Logger{Filename: filename, MaxSize: 5}
+
+	n, err := l.WriteString("booooooooooooooo!")
+	notNil(err, t)
+	equals(0, n, t)
+	notExist(filename, t)
+
+	// After Close, the string is written by an open-write-close cycle.
+	isNil(l.Close(), t)
+	n, err = l.WriteString("boo!")
+	isNil(err, t)
+	equals(4, n, t)
+	existsWithContent(filename, []byte("boo!"), t)
+}
+
 func TestMakeLogDir(t *testing.T) {
 	currentTime = fakeTime
 	dir := time.Now().Format("TestMakeLogDir" + backupTimeFormat)
@@ -502,7 +644,7 @@ func TestTimeFromName(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		got, err := l.timeFromName(test.filename, prefix, ext)
+		got, err := l.timeFromName(test.filename, prefix, ext, l.backupLayouts())
 		equals(got, test.want, t)
 		equals(err != nil, test.wantErr, t)
 	}
@@ -753,6 +895,8 @@ func fileCount(dir string, exp int, t testing.TB) {
 
 // newFakeTime sets the fake "current time" to two days later.
 func newFakeTime() {
+	fakeTimeMu.Lock()
+	defer fakeTimeMu.Unlock()
 	fakeCurrentTime = fakeCurrentTime.Add(time.Hour * 24 * 2)
 }
 
@@ -880,7 +1024,7 @@ func TestRotateAtMinutes(t *testing.T) {
 
 	// 1) Start just before the 14:00 mark (e.g. 14:00:59 UTC)
 	initial := time.Date(2025, time.May, 12, 14, 0, 59, 0, time.UTC)
-	fakeCurrentTime = initial
+	setFakeTime(initial)
 
 	dir := makeTempDir("TestRotateAtMinutes", t)
 	defer os.RemoveAll(dir)
@@ -895,7 +1039,7 @@ func TestRotateAtMinutes(t *testing.T) {
 	defer l.Close() // stop scheduling goroutine
 
 	// 2) Write at 14:01 → no rotation yet
-	fakeCurrentTime = time.Date(2025, time.May, 12, 14, 1, 0, 0, time.UTC)
+	setFakeTime(time.Date(2025, time.May, 12, 14, 1, 0, 0, time.UTC))
 	n, err := l.Write(content1)
 	isNil(err, t)
 	equals(len(content1), n, t)
@@ -903,11 +1047,11 @@ func TestRotateAtMinutes(t *testing.T) {
 	fileCount(dir, 1, t) // only the live logfile
 
 	// 3) Advance to 14:15 exactly, let the goroutine fire
-	fakeCurrentTime = time.Date(2025, time.May, 12, 14, 15, 0, 0, time.UTC)
+	setFakeTime(time.Date(2025, time.May, 12, 14, 15, 0, 0, time.UTC))
 	time.Sleep(300 * time.Millisecond)
 
 	// 4) Write at 14:16 → should be on a fresh file, and first-backup is content1
-	fakeCurrentTime = time.Date(2025, time.May, 12, 14, 16, 0, 0, time.UTC)
+	setFakeTime(time.Date(2025, time.May, 12, 14, 16, 0, 0, time.UTC))
 	n, err = l.Write(content2)
 	isNil(err, t)
 	equals(len(content2), n, t)
@@ -917,12 +1061,12 @@ func TestRotateAtMinutes(t *testing.T) {
 	fileCount(dir, 2, t)
 
 	// 5) Advance past the 14:30 mark without writing → no new rotation
-	fakeCurrentTime = time.Date(2025, time.May, 12, 14, 30, 0, 0, time.UTC)
+	setFakeTime(time.Date(2025, time.May, 12, 14, 30, 0, 0, time.UTC))
 	time.Sleep(300 * time.Millisecond)
 	fileCount(dir, 2, t) // still just the live log + one backup
 
 	// 6) Write at 14:31 → triggers the 30-minute mark rotation, and rolls content2
-	fakeCurrentTime = time.Date(2025, time.May, 12, 14, 31, 0, 0, time.UTC)
+	setFakeTime(time.Date(2025, time.May, 12, 14, 31, 0, 0, time.UTC))
 	n, err = l.Write(content3)
 	isNil(err, t)
 	equals(len(content3), n, t)
@@ -1037,19 +1181,19 @@ func TestTimeFromName_InvalidFormat(t *testing.T) {
 	prefix, ext := logger.prefixAndExt()
 
 	// Case 1: mismatched prefix
-	_, err := logger.timeFromName("badname.log", prefix, ext)
+	_, err := logger.timeFromName("badname.log", prefix, ext, logger.backupLayouts())
 	if err == nil || !strings.Contains(err.Error(), "mismatched prefix") {
 		t.Fatalf("expected mismatched prefix error, got: %v", err)
 	}
 
 	// Case 2: mismatched extension
-	_, err = logger.timeFromName("foo-2020-01-01T00-00-00.000-size.txt", prefix, ext)
+	_, err = logger.timeFromName("foo-2020-01-01T00-00-00.000-size.txt", prefix, ext, logger.backupLayouts())
 	if err == nil || !strings.Contains(err.Error(), "mismatched extension") {
 		t.Fatalf("expected mismatched extension error, got: %v", err)
 	}
 
 	// Case 3: malformed timestamp structure
-	_, err = logger.timeFromName("foo-2020-01-01T00-00-size.log", prefix, ext)
+	_, err = logger.timeFromName("foo-2020-01-01T00-00-size.log", prefix, ext, logger.backupLayouts())
 	if err == nil || !strings.Contains(err.Error(), "cannot parse") {
 		t.Fatalf("expected time parse error, got: %v", err)
 	}
@@ -1126,7 +1270,7 @@ func TestRotate_OpenNewFails(t *testing.T) {
 		Filename: badPath,
 	}
 	// force an invalid path to trigger openNew failure
-	err := l.rotate("manual")
+	err := l.rotate(ReasonManual)
 	if err == nil {
 		t.Fatal("expected error from rotate due to invalid openNew")
 	}
@@ -1382,7 +1526,7 @@ func TestTimeFromName_MalformedFilename(t *testing.T) {
 	// Missing final hyphen separator, so no reason part
 	invalid := "foo-20200101T000000000.log"
 
-	_, err := logger.timeFromName(invalid, prefix, ext)
+	_, err := logger.timeFromName(invalid, prefix, ext, logger.backupLayouts())
 	if err == nil || !strings.Contains(err.Error(), "malformed backup filename") {
 		t.Fatalf("expected malformed filename error, got: %v", err)
 	}
@@ -1823,15 +1967,16 @@ func TestMillRun_TriggersMillRunOnce_Effect(t *testing.T) {
 	}
 
 	// Start millRun in background
-	go l.millRun()
+	l.millWg.Add(1)
+	go l.millRun(l.millCh)
 
 	// Trigger it
 	l.millCh <- true
 	time.Sleep(100 * time.Millisecond)
 	close(l.millCh)
 
-	// Wait briefly for compression to complete
-	time.Sleep(200 * time.Millisecond)
+	// Wait for compression to complete
+	l.millWg.Wait()
 
 	// Check if file was compressed
 	_, err := os.Stat(backup + ".gz")
@@ -1864,7 +2009,7 @@ func TestRotate_StartMillOnlyOnce_Observable(t *testing.T) {
 	}
 
 	// Rotate once — triggers millRun and startMill.Do
-	if err := logger.rotate("size"); err != nil {
+	if err := logger.rotate(ReasonSize); err != nil {
 		t.Fatalf("rotate failed: %v", err)
 	}
 
@@ -2310,7 +2455,8 @@ func TestLoggerClose_ClosesMillChannel(t *testing.T) {
 
 	// Set startMill to run millRun (to simulate actual usage)
 	logger.startMill.Do(func() {
-		go logger.millRun()
+		logger.millWg.Add(1)
+		go logger.millRun(logger.millCh)
 	})
 
 	// Close should close millCh
@@ -2576,3 +2722,4309 @@ func TestWriteToClosedLogger(t *testing.T) {
 		t.Errorf("File content mismatch.\nExpected: %q\nGot:      %q", expectedContent, fileContent)
 	}
 }
+
+func TestTriggerFileRotation(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	origInterval := triggerPollInterval
+	triggerPollInterval = 10 * time.Millisecond
+	defer func() { triggerPollInterval = origInterval }()
+
+	dir := makeTempDir("TestTriggerFileRotation", t)
+	defer os.RemoveAll(dir)
+
+	filename := logFile(dir)
+	trigger := filename + ".rotate"
+	l := &Logger{
+		Filename:    filename,
+		MaxSize:     100,
+		TriggerFile: trigger,
+	}
+	defer l.Close()
+
+	b := []byte("before trigger\n")
+	n, err := l.Write(b)
+	isNil(err, t)
+	equals(len(b), n, t)
+
+	isNil(os.WriteFile(trigger, nil, 0644), t)
+	time.Sleep(200 * time.Millisecond)
+
+	// The trigger must be consumed and the old content moved to a backup.
+	notExist(trigger, t)
+	existsWithContent(backupFileWithReason(dir, "size"), b, t)
+	existsWithContent(filename, []byte{}, t)
+
+	b2 := []byte("after trigger\n")
+	n, err = l.Write(b2)
+	isNil(err, t)
+	equals(len(b2), n, t)
+	existsWithContent(filename, b2, t)
+	fileCount(dir, 2, t)
+}
+
+func TestTriggerFileWatcherStopsOnClose(t *testing.T) {
+	defer leaktest.Check(t)()
+	origInterval := triggerPollInterval
+	triggerPollInterval = 10 * time.Millisecond
+	defer func() { triggerPollInterval = origInterval }()
+
+	dir := t.TempDir()
+	l := &Logger{
+		Filename:    filepath.Join(dir, "foobar.log"),
+		TriggerFile: filepath.Join(dir, "foobar.log.rotate"),
+	}
+	_, err := l.Write([]byte("data\n"))
+	isNil(err, t)
+	isNil(l.Close(), t)
+}
+
+func TestRotateTo(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+
+	dir := makeTempDir("TestRotateTo", t)
+	defer os.RemoveAll(dir)
+
+	filename := logFile(dir)
+	l := &Logger{
+		Filename:   filename,
+		MaxSize:    100,
+		MaxBackups: 1,
+	}
+	defer l.Close()
+
+	b := []byte("harvest me\n")
+	_, err := l.Write(b)
+	isNil(err, t)
+
+	dest := filepath.Join(dir, "upload", "harvested.log")
+	err = l.RotateTo(dest)
+	isNil(err, t)
+
+	existsWithContent(dest, b, t)
+	existsWithContent(filename, []byte{}, t)
+
+	b2 := []byte("still logging\n")
+	_, err = l.Write(b2)
+	isNil(err, t)
+	existsWithContent(filename, b2, t)
+
+	// The harvested file isn't a backup, so cleanup must leave it alone.
+	newFakeTime()
+	isNil(l.Rotate(), t)
+	newFakeTime()
+	isNil(l.Rotate(), t)
+	isNil(l.Close(), t) // waits for the cleanup run
+	existsWithContent(dest, b, t)
+}
+
+func TestRotateToWithoutActiveFile(t *testing.T) {
+	currentTime = fakeTime
+	dir := makeTempDir("TestRotateToWithoutActiveFile", t)
+	defer os.RemoveAll(dir)
+
+	l := &Logger{Filename: logFile(dir)}
+	defer l.Close()
+
+	dest := filepath.Join(dir, "harvested.log")
+	isNil(l.RotateTo(dest), t)
+	notExist(dest, t)
+	existsWithContent(logFile(dir), []byte{}, t)
+
+	isNil(l.Close(), t)
+	notNil(l.RotateTo(dest), t)
+}
+
+func TestRotateToHooks(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	auditFile := filepath.Join(t.TempDir(), "audit.jsonl")
+	var synced []string
+	origSyncPath := syncPath
+	syncPath = func(path string) error {
+		synced = append(synced, path)
+		return nil
+	}
+	defer func() { syncPath = origSyncPath }()
+
+	var rotated []string
+	l := &Logger{
+		Filename:             logFile(dir),
+		AuditFile:            auditFile,
+		SyncBackupBeforeHook: true,
+		TrackLatency:         true,
+		OnRotate:             func(_ RotationReason, backup string) { rotated = append(rotated, backup) },
+	}
+	defer l.Close()
+	_, err := l.Write([]byte("harvest me\n"))
+	isNil(err, t)
+
+	// RotateTo is handled like any other rotation.
+	dest := filepath.Join(dir, "upload", "harvested.log")
+	isNil(l.RotateTo(dest), t)
+	equals([]string{dest}, rotated, t)
+	equals([]string{dest}, synced, t)
+	equals(int64(1), l.Stats().RotationLatency.Count, t)
+	entries := readAuditFile(auditFile, t)
+	equals(1, len(entries), t)
+	equals("rotate", entries[0].Event, t)
+	equals(dest, entries[0].File, t)
+	equals("manual", entries[0].Reason, t)
+}
+
+// A backup caught mid-compression exists both as .log and .log.gz. Both files
+// belong to the same rotation event and must count once towards MaxBackups.
+func TestMaxBackupsCountsCompressionPairOnce(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress=%v", compress), func(t *testing.T) {
+			currentTime = fakeTime
+			dir := t.TempDir()
+			l := &Logger{
+				Filename:   filepath.Join(dir, "test.log"),
+				MaxBackups: 2,
+				Compress:   compress,
+			}
+
+			oldest := filepath.Join(dir, "test-2025-01-01T00-00-00.000-size.log")
+			middle := filepath.Join(dir, "test-2025-01-02T00-00-00.000-size.log")
+			newest := filepath.Join(dir, "test-2025-01-03T00-00-00.000-size.log")
+			isNil(os.WriteFile(oldest+compressSuffix, []byte("old"), 0644), t)
+			isNil(os.WriteFile(middle, []byte("middle content"), 0644), t)
+			isNil(os.WriteFile(middle+compressSuffix, []byte("partial"), 0644), t) // interrupted compression
+			isNil(os.WriteFile(newest, []byte("newest content"), 0644), t)
+
+			isNil(l.millRunOnce(), t)
+
+			// Only the oldest event falls outside MaxBackups.
+			notExist(oldest+compressSuffix, t)
+			if !compress {
+				existsWithContent(middle, []byte("middle content"), t)
+				existsWithContent(middle+compressSuffix, []byte("partial"), t)
+				existsWithContent(newest, []byte("newest content"), t)
+				fileCount(dir, 3, t)
+				return
+			}
+
+			// With compression on, the complete source replaces the partial archive.
+			notExist(middle, t)
+			notExist(newest, t)
+			for path, want := range map[string]string{
+				middle + compressSuffix: "middle content",
+				newest + compressSuffix: "newest content",
+			} {
+				f, err := os.Open(path)
+				isNil(err, t)
+				gz, err := gzip.NewReader(f)
+				isNil(err, t)
+				got, err := io.ReadAll(gz)
+				isNil(err, t)
+				f.Close()
+				equals(want, string(got), t)
+			}
+			fileCount(dir, 2, t)
+		})
+	}
+}
+
+// Both forms of a backup must expire together under MaxAge.
+func TestMaxAgeRemovesCompressionPairTogether(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	l := &Logger{
+		Filename: filepath.Join(dir, "test.log"),
+		MaxAge:   1,
+	}
+
+	old := fakeTime().Add(-72 * time.Hour).UTC().Format(backupTimeFormat)
+	stale := filepath.Join(dir, "test-"+old+"-size.log")
+	isNil(os.WriteFile(stale, []byte("data"), 0644), t)
+	isNil(os.WriteFile(stale+compressSuffix, []byte("partial"), 0644), t)
+
+	isNil(l.millRunOnce(), t)
+	notExist(stale, t)
+	notExist(stale+compressSuffix, t)
+}
+
+func TestRotationBufferSize(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+
+	dir := makeTempDir("TestRotationBufferSize", t)
+	defer os.RemoveAll(dir)
+
+	// Make the rename step of the rotation slow.
+	renameStarted := make(chan struct{})
+	origRename := osRename
+	osRename = func(oldpath, newpath string) error {
+		close(renameStarted)
+		time.Sleep(300 * time.Millisecond)
+		return os.Rename(oldpath, newpath)
+	}
+	defer func() { osRename = origRename }()
+
+	filename := logFile(dir)
+	l := &Logger{
+		Filename:           filename,
+		MaxSize:            100,
+		RotationBufferSize: 64,
+	}
+	defer l.Close()
+
+	b := []byte("before rotation\n")
+	_, err := l.Write(b)
+	isNil(err, t)
+
+	rotateDone := make(chan error)
+	go func() { rotateDone <- l.Rotate() }()
+	<-renameStarted
+
+	var want []byte
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		line := []byte(fmt.Sprintf("buffered %d\n", i))
+		n, err := l.Write(line)
+		isNil(err, t)
+		equals(len(line), n, t)
+		want = append(want, line...)
+	}
+	assert(time.Since(start) < 200*time.Millisecond, t, "buffered writes waited for the rotation")
+
+	// This one doesn't fit in the remaining buffer, so it waits for the rotation.
+	big := bytes.Repeat([]byte("x"), 40)
+	big[len(big)-1] = '\n'
+	_, err = l.Write(big)
+	isNil(err, t)
+	want = append(want, big...)
+
+	isNil(<-rotateDone, t)
+
+	existsWithContent(backupFileWithReason(dir, "size"), b, t)
+	existsWithContent(filename, want, t)
+	equals(int64(len(want)), l.size, t)
+}
+
+func TestRotationBufferSizeOverflowsNewFile(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := t.TempDir()
+	filename := logFile(dir)
+	l := &Logger{Filename: filename, MaxSize: 10, RotationBufferSize: 10}
+	defer l.Close()
+
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+
+	// Another write comes in while the size rotation is renaming the file.
+	buffered := []byte("0123456")
+	firstBackup := backupFileWithReason(dir, "size")
+	origRename := osRename
+	osRename = func(oldpath, newpath string) error {
+		err := origRename(oldpath, newpath)
+		if newpath == firstBackup {
+			n, errWrite := l.Write(buffered)
+			isNil(errWrite, t)
+			equals(len(buffered), n, t)
+			newFakeTime()
+		}
+		return err
+	}
+	defer func() { osRename = origRename }()
+
+	// With the buffered write, this one no longer fits in the new file.
+	b := []byte("abcdefgh")
+	_, err = l.Write(b)
+	isNil(err, t)
+	existsWithContent(firstBackup, []byte("boo!"), t)
+	existsWithContent(backupFileWithReason(dir, "size"), buffered, t)
+	existsWithContent(filename, b, t)
+	fileCount(dir, 3, t)
+}
+
+func TestOpenBackup(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("line one\nline two\n")
+
+	plain := filepath.Join(dir, "foobar-2025-01-01T00-00-00.000-size.log")
+	isNil(os.WriteFile(plain, content, 0644), t)
+
+	compressed := filepath.Join(dir, "foobar-2025-01-02T00-00-00.000-size.log")
+	isNil(os.WriteFile(compressed, content, 0644), t)
+	isNil(compressLogFile(compressed, compressed+compressSuffix), t)
+
+	for _, path := range []string{plain, compressed + compressSuffix} {
+		rc, err := OpenBackup(path)
+		isNil(err, t)
+		got, err := io.ReadAll(rc)
+		isNil(err, t)
+		isNil(rc.Close(), t)
+		equals(content, got, t)
+	}
+}
+
+func TestOpenBackupInvalidGzip(t *testing.T) {
+	dir := t.TempDir()
+	bogus := filepath.Join(dir, "foobar-2025-01-01T00-00-00.000-size.log.gz")
+	isNil(os.WriteFile(bogus, []byte("not gzip at all"), 0644), t)
+
+	rc, err := OpenBackup(bogus)
+	notNil(err, t)
+	isNil(rc, t)
+	assert(strings.Contains(err.Error(), "not a valid gzip file"), t, "unexpected error: %v", err)
+
+	_, err = OpenBackup(filepath.Join(dir, "missing.log"))
+	assert(errors.Is(err, os.ErrNotExist), t, "expected not-exist error, got %v", err)
+}
+
+func TestOpenBackupFromBackups(t *testing.T) {
+	dir := t.TempDir()
+	l := &Logger{Filename: logFile(dir)}
+	defer l.Close()
+
+	plain := filepath.Join(dir, "foobar-2025-01-01T00-00-00.000-size.log")
+	isNil(os.WriteFile(plain, []byte("older\n"), 0644), t)
+	compressed := filepath.Join(dir, "foobar-2025-01-02T00-00-00.000-time.log")
+	isNil(os.WriteFile(compressed, []byte("newer\n"), 0644), t)
+	isNil(compressLogFile(compressed, compressed+compressSuffix), t)
+
+	// Tooling can read whatever Backups lists without looking at the extension.
+	backups, err := l.Backups()
+	isNil(err, t)
+	equals(2, len(backups), t)
+	var got []string
+	for _, b := range backups {
+		rc, err := OpenBackup(b.Path)
+		isNil(err, t)
+		content, err := io.ReadAll(rc)
+		isNil(err, t)
+		isNil(rc.Close(), t)
+		got = append(got, string(content))
+	}
+	equals([]string{"newer\n", "older\n"}, got, t)
+	equals(true, backups[0].Compressed, t)
+}
+
+func TestZeroLengthWriteDoesNotCreateFile(t *testing.T) {
+	currentTime = fakeTime
+	dir := makeTempDir("TestZeroLengthWriteDoesNotCreateFile", t)
+	defer os.RemoveAll(dir)
+
+	filename := logFile(dir)
+	l := &Logger{
+		Filename:         filename,
+		RotationInterval: time.Second,
+	}
+	defer l.Close()
+
+	for _, p := range [][]byte{nil, {}} {
+		n, err := l.Write(p)
+		isNil(err, t)
+		equals(0, n, t)
+	}
+	notExist(filename, t)
+	fileCount(dir, 0, t)
+
+	// Zero-length writes must not trigger a due rotation either.
+	b := []byte("boo!")
+	_, err := l.Write(b)
+	isNil(err, t)
+	l.lastRotationTime = fakeTime().Add(-time.Hour)
+	_, err = l.Write(nil)
+	isNil(err, t)
+	existsWithContent(filename, b, t)
+	fileCount(dir, 1, t)
+}
+
+func TestMillMaxConsecutiveErrors(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+
+	var readDirCalls int32
+	origReadDir := osReadDir
+	osReadDir = func(string) ([]os.DirEntry, error) {
+		atomic.AddInt32(&readDirCalls, 1)
+		return nil, errors.New("directory unavailable")
+	}
+	defer func() { osReadDir = origReadDir }()
+
+	var mu sync.Mutex
+	var reported []error
+	l := &Logger{
+		Filename:                 filepath.Join(dir, "foobar.log"),
+		MaxBackups:               1,
+		MillMaxConsecutiveErrors: 3,
+		OnRotationError: func(err error) {
+			mu.Lock()
+			reported = append(reported, err)
+			mu.Unlock()
+		},
+	}
+	defer l.Close()
+
+	for i := 0; i < 6; i++ {
+		l.mill()
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Three failures, then the budget is exhausted and the goroutine stops.
+	equals(int32(3), atomic.LoadInt32(&readDirCalls), t)
+	mu.Lock()
+	equals(4, len(reported), t)
+	assert(errors.Is(reported[3], ErrMillStopped), t, "expected ErrMillStopped, got %v", reported[3])
+	mu.Unlock()
+
+	stats := l.Stats()
+	equals(int64(3), stats.MillErrors, t)
+	equals(int64(3), stats.MillConsecutiveErrors, t)
+	equals(true, stats.MillStopped, t)
+}
+
+func TestMillConsecutiveErrorsReset(t *testing.T) {
+	dir := t.TempDir()
+
+	fail := int32(1)
+	origReadDir := osReadDir
+	osReadDir = func(name string) ([]os.DirEntry, error) {
+		if atomic.LoadInt32(&fail) == 1 {
+			return nil, errors.New("directory unavailable")
+		}
+		return os.ReadDir(name)
+	}
+	defer func() { osReadDir = origReadDir }()
+
+	l := &Logger{
+		Filename:                 filepath.Join(dir, "foobar.log"),
+		MaxBackups:               1,
+		MillMaxConsecutiveErrors: 2,
+	}
+	defer l.Close()
+
+	l.mill()
+	time.Sleep(20 * time.Millisecond)
+	atomic.StoreInt32(&fail, 0)
+	l.mill()
+	time.Sleep(20 * time.Millisecond)
+
+	stats := l.Stats()
+	equals(int64(1), stats.MillErrors, t)
+	equals(int64(0), stats.MillConsecutiveErrors, t)
+	equals(false, stats.MillStopped, t)
+}
+
+func TestSetFile(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+
+	dir := makeTempDir("TestSetFile", t)
+	defer os.RemoveAll(dir)
+
+	filename := logFile(dir)
+	existing := []byte("inherited\n")
+	isNil(os.WriteFile(filename, existing, 0644), t)
+
+	// Deliberately not O_APPEND: SetFile must still write at the end.
+	f, err := os.OpenFile(filename, os.O_WRONLY, 0644)
+	isNil(err, t)
+
+	l := &Logger{MaxSize: 100}
+	defer l.Close()
+	isNil(l.SetFile(f), t)
+	equals(filename, l.Filename, t)
+	equals(int64(len(existing)), l.size, t)
+
+	b := []byte("adopted write\n")
+	_, err = l.Write(b)
+	isNil(err, t)
+	existsWithContent(filename, append(existing, b...), t)
+
+	newFakeTime()
+	isNil(l.Rotate(), t)
+	existsWithContent(backupFileWithReason(dir, "size"), append(existing, b...), t)
+
+	b2 := []byte("after rotation\n")
+	_, err = l.Write(b2)
+	isNil(err, t)
+	existsWithContent(filename, b2, t)
+	fileCount(dir, 2, t)
+}
+
+func TestBackupsSince(t *testing.T) {
+	dir := t.TempDir()
+	l := &Logger{Filename: filepath.Join(dir, "foobar.log")}
+
+	names := []string{
+		"foobar-2025-01-01T00-00-00.000-size.log.gz",
+		"foobar-2025-01-02T00-00-00.000-time.log.gz",
+		"foobar-2025-01-03T00-00-00.000-size.log",
+		"foobar-2025-01-04T00-00-00.000-size.log",
+	}
+	for _, name := range names {
+		isNil(os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644), t)
+	}
+	isNil(os.WriteFile(filepath.Join(dir, "foobar.log"), []byte("active"), 0644), t)
+
+	cutoff := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	got, err := l.BackupsSince(cutoff)
+	isNil(err, t)
+
+	// The backup stamped exactly at the cutoff is excluded.
+	equals(2, len(got), t)
+	equals(filepath.Join(dir, names[3]), got[0].Path, t)
+	equals(filepath.Join(dir, names[2]), got[1].Path, t)
+	equals(false, got[0].Compressed, t)
+
+	// Using the newest timestamp as the next high-water mark yields nothing new.
+	got, err = l.BackupsSince(got[0].Timestamp)
+	isNil(err, t)
+	equals(0, len(got), t)
+
+	got, err = l.BackupsSince(time.Time{})
+	isNil(err, t)
+	equals(4, len(got), t)
+	equals(true, got[3].Compressed, t)
+	equals(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), got[3].Timestamp, t)
+	equals(int64(1), got[3].Size, t)
+}
+
+func TestDiskUsage(t *testing.T) {
+	dir := t.TempDir()
+	l := &Logger{Filename: filepath.Join(dir, "foobar.log")}
+
+	// Nothing on disk yet.
+	active, backups, total, err := l.DiskUsage()
+	isNil(err, t)
+	equals([3]int64{0, 0, 0}, [3]int64{active, backups, total}, t)
+
+	files := map[string]int{
+		"foobar.log": 100,
+		"foobar-2025-01-01T00-00-00.000-size.log.gz":   20,
+		"foobar-2025-01-01T00-00-00.000-size.log.meta": 3,
+		"foobar-2025-01-01T00-00-00.000-size.log.idx":  4,
+		"foobar-2025-01-02T00-00-00.000-time.log":      50,
+		"foobar-2025-01-02T00-00-00.000-time.log.meta": 5,
+		"other-2025-01-02T00-00-00.000-time.log":       1000, // not ours
+		"notes.txt":                                    1000,
+	}
+	for name, size := range files {
+		isNil(os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0644), t)
+	}
+
+	active, backups, total, err = l.DiskUsage()
+	isNil(err, t)
+	equals(int64(100), active, t)
+	equals(int64(20+3+4+50+5), backups, t)
+	equals(active+backups, total, t)
+}
+
+func TestCompressBufferSize(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "foobar-2025-01-01T00-00-00.000-size.log")
+	content := bytes.Repeat([]byte("some log line that repeats\n"), 1000)
+	isNil(os.WriteFile(src, content, 0644), t)
+
+	// A tiny buffer forces many copy iterations.
+	isNil(compressLogFileWith(src, src+compressSuffix, compressOptions{bufferSize: 7}), t)
+	notExist(src, t)
+
+	rc, err := OpenBackup(src + compressSuffix)
+	isNil(err, t)
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	isNil(err, t)
+	equals(content, got, t)
+}
+
+func TestCompressLevel(t *testing.T) {
+	dir := t.TempDir()
+	content := bytes.Repeat([]byte("some log line that repeats\n"), 1000)
+
+	// The gzip header's XFL byte records the fastest (4) or best (2) compression.
+	for level, xfl := range map[int]byte{gzip.BestSpeed: 4, gzip.BestCompression: 2, 0: 0} {
+		l := &Logger{CompressLevel: level}
+		src := filepath.Join(dir, fmt.Sprintf("level%d.log", level))
+		isNil(os.WriteFile(src, content, 0644), t)
+		isNil(compressLogFileWith(src, src+compressSuffix, l.compressOptions()), t)
+
+		compressed, err := os.ReadFile(src + compressSuffix)
+		isNil(err, t)
+		equals(xfl, compressed[8], t)
+
+		rc, err := OpenBackup(src + compressSuffix)
+		isNil(err, t)
+		got, err := io.ReadAll(rc)
+		isNil(err, t)
+		isNil(rc.Close(), t)
+		equals(content, got, t)
+	}
+
+	// Out-of-range levels use the default.
+	for _, level := range []int{-5, gzip.HuffmanOnly, 10} {
+		l := &Logger{CompressLevel: level}
+		equals(gzip.DefaultCompression, l.compressLevel(), t)
+	}
+}
+
+func BenchmarkCompressLogFile(b *testing.B) {
+	content := bytes.Repeat([]byte("2025-01-01T00:00:00Z INFO request served path=/api/v1/items status=200\n"), 64*1024)
+	for _, size := range []int{0, 32 * 1024, 256 * 1024, 1024 * 1024} {
+		b.Run(fmt.Sprintf("buffer=%d", size), func(b *testing.B) {
+			dir := b.TempDir()
+			src := filepath.Join(dir, "bench.log")
+			b.SetBytes(int64(len(content)))
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				if err := os.WriteFile(src, content, 0644); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+				if err := compressLogFileWith(src, src+compressSuffix, compressOptions{bufferSize: size}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestAppendOpenFailurePolicy(t *testing.T) {
+	errDenied := errors.New("permission denied")
+	origOpenFile := osOpenFile
+	osOpenFile = func(name string, flag int, perm os.FileMode) (*os.File, error) {
+		if flag&os.O_APPEND != 0 {
+			return nil, errDenied
+		}
+		return os.OpenFile(name, flag, perm)
+	}
+	defer func() { osOpenFile = origOpenFile }()
+
+	for _, propagate := range []bool{false, true} {
+		t.Run(fmt.Sprintf("propagate=%v", propagate), func(t *testing.T) {
+			currentTime = fakeTime
+			dir := t.TempDir()
+			filename := filepath.Join(dir, "foobar.log")
+			existing := []byte("existing\n")
+			isNil(os.WriteFile(filename, existing, 0644), t)
+
+			l := &Logger{
+				Filename:              filename,
+				FailOnAppendOpenError: propagate,
+			}
+			defer l.Close()
+
+			b := []byte("new data\n")
+			n, err := l.Write(b)
+			if propagate {
+				assert(errors.Is(err, errDenied), t, "expected append-open error, got %v", err)
+				equals(0, n, t)
+				existsWithContent(filename, existing, t)
+				fileCount(dir, 1, t)
+				return
+			}
+
+			// Default: the unappendable file is moved aside and logging continues.
+			isNil(err, t)
+			equals(len(b), n, t)
+			existsWithContent(filename, b, t)
+			existsWithContent(backupFileWithReason(dir, "initial"), existing, t)
+			fileCount(dir, 2, t)
+		})
+	}
+}
+
+func TestJitterRandReproducible(t *testing.T) {
+	seq := func(seed int64) []time.Duration {
+		l := &Logger{
+			RotationJitter: time.Minute,
+			JitterRand:     rand.New(rand.NewSource(seed)),
+		}
+		var out []time.Duration
+		for i := 0; i < 5; i++ {
+			d := l.nextJitter()
+			assert(d >= 0 && d < time.Minute, t, "jitter %v out of range", d)
+			out = append(out, d)
+		}
+		return out
+	}
+	equals(seq(42), seq(42), t)
+	assert(!reflect.DeepEqual(seq(42), seq(43)), t, "different seeds produced the same sequence")
+
+	// Without RotationJitter there is never any delay.
+	l := &Logger{JitterRand: rand.New(rand.NewSource(1))}
+	equals(time.Duration(0), l.nextJitter(), t)
+}
+
+func TestRotationJitterDelaysIntervalRotation(t *testing.T) {
+	currentTime = fakeTime
+	dir := makeTempDir("TestRotationJitterDelaysIntervalRotation", t)
+	defer os.RemoveAll(dir)
+
+	const seed = 7
+	expected := time.Duration(rand.New(rand.NewSource(seed)).Int63n(int64(time.Minute)))
+
+	filename := logFile(dir)
+	l := &Logger{
+		Filename:         filename,
+		RotationInterval: time.Hour,
+		RotationJitter:   time.Minute,
+		JitterRand:       rand.New(rand.NewSource(seed)),
+	}
+	defer l.Close()
+
+	start := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	setFakeTime(start)
+	_, err := l.Write([]byte("first\n"))
+	isNil(err, t)
+	equals(expected, l.rotationJitter, t)
+
+	// The plain interval has elapsed, but the jittered deadline hasn't.
+	setFakeTime(start.Add(time.Hour + expected - time.Millisecond))
+	_, err = l.Write([]byte("second\n"))
+	isNil(err, t)
+	fileCount(dir, 1, t)
+
+	setFakeTime(start.Add(time.Hour + expected))
+	_, err = l.Write([]byte("third\n"))
+	isNil(err, t)
+	fileCount(dir, 2, t)
+	existsWithContent(filename, []byte("third\n"), t)
+}
+
+func TestPurgeBackups(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := makeTempDir("TestPurgeBackups", t)
+	defer os.RemoveAll(dir)
+
+	filename := logFile(dir)
+	l := &Logger{
+		Filename: filename,
+		MaxSize:  100,
+	}
+	defer l.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := l.Write([]byte(fmt.Sprintf("write %d\n", i)))
+		isNil(err, t)
+		newFakeTime()
+		isNil(l.Rotate(), t)
+	}
+	isNil(os.WriteFile(filepath.Join(dir, "foobar-2025-01-01T00-00-00.000-size.log.gz"), []byte("x"), 0644), t)
+	unrelated := filepath.Join(dir, "unrelated.txt")
+	isNil(os.WriteFile(unrelated, []byte("keep me"), 0644), t)
+
+	last := []byte("active\n")
+	_, err := l.Write(last)
+	isNil(err, t)
+	fileCount(dir, 6, t)
+
+	removed, err := l.PurgeBackups()
+	isNil(err, t)
+	equals(4, removed, t)
+
+	existsWithContent(filename, last, t)
+	exists(unrelated, t)
+	fileCount(dir, 2, t)
+
+	removed, err = l.PurgeBackups()
+	isNil(err, t)
+	equals(0, removed, t)
+}
+
+func TestRotateBoundaryFunc(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := makeTempDir("TestRotateBoundaryFunc", t)
+	defer os.RemoveAll(dir)
+
+	var seen [][]byte
+	filename := logFile(dir)
+	l := &Logger{
+		Filename: filename,
+		MaxSize:  20,
+		RotateBoundaryFunc: func(lastBytes []byte) bool {
+			seen = append(seen, append([]byte(nil), lastBytes...))
+			return bytes.HasSuffix(lastBytes, []byte("\n"))
+		},
+	}
+	defer l.Close()
+
+	writes := []string{`{"msg":"hel`, `lo"}` + "\n" + `{"m`, `sg":1}` + "\n"}
+	for _, w := range writes {
+		_, err := l.Write([]byte(w))
+		isNil(err, t)
+	}
+
+	// The last write crossed MaxSize while the file ended mid-object: deferred.
+	fileCount(dir, 1, t)
+	equals(1, len(seen), t)
+	equals(`lo"}`+"\n"+`{"m`, string(seen[0][len(seen[0])-8:]), t)
+	existsWithContent(filename, []byte(strings.Join(writes, "")), t)
+
+	// Now the file ends on a complete object, so the next crossing write rotates.
+	last := []byte(`{"x":1}` + "\n")
+	_, err := l.Write(last)
+	isNil(err, t)
+	fileCount(dir, 2, t)
+	existsWithContent(backupFileWithReason(dir, "size"), []byte(strings.Join(writes, "")), t)
+	existsWithContent(filename, last, t)
+}
+
+func TestLineAware(t *testing.T) {
+	for _, soft := range []bool{false, true} {
+		t.Run(fmt.Sprintf("SoftMaxSize=%v", soft), func(t *testing.T) {
+			currentTime = fakeTime
+			megabyte = 1
+			dir := t.TempDir()
+			filename := logFile(dir)
+			l := &Logger{Filename: filename, MaxSize: 16, SoftMaxSize: soft, LineAware: true}
+			defer l.Close()
+
+			// Lines of varying length, each written in pieces.
+			var want bytes.Buffer
+			for i := 0; i < 40; i++ {
+				line := fmt.Sprintf("line %d %s\n", i, strings.Repeat("x", i%7))
+				for len(line) > 0 {
+					piece := line
+					if len(piece) > 3 {
+						piece = piece[:3]
+					}
+					line = line[len(piece):]
+					newFakeTime() // a distinct name for every backup
+					_, err := l.Write([]byte(piece))
+					isNil(err, t)
+					want.WriteString(piece)
+				}
+			}
+
+			backups, err := l.BackupsSorted(OldestFirst)
+			isNil(err, t)
+			assert(len(backups) > 5, t, "expected several rotations, got %d", len(backups))
+			var got []byte
+			for _, b := range backups {
+				data, err := os.ReadFile(b.Path)
+				isNil(err, t)
+				assert(bytes.HasSuffix(data, []byte("\n")), t, "backup %s ends mid-line: %q", b.Path, data)
+				got = append(got, data...)
+			}
+			active, err := os.ReadFile(filename)
+			isNil(err, t)
+			equals(want.String(), string(append(got, active...)), t)
+		})
+	}
+}
+
+func TestPersistStateAcrossRestart(t *testing.T) {
+	currentTime = fakeTime
+	dir := makeTempDir("TestPersistStateAcrossRestart", t)
+	defer os.RemoveAll(dir)
+
+	filename := logFile(dir)
+	newLogger := func() *Logger {
+		return &Logger{
+			Filename:         filename,
+			RotationInterval: time.Hour,
+			PersistState:     true,
+		}
+	}
+
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	setFakeTime(start)
+	l := newLogger()
+	_, err := l.Write([]byte("before restart\n"))
+	isNil(err, t)
+	isNil(l.Close(), t)
+	exists(filename+".state", t)
+
+	// "Restart" 59 minutes later: the interval still counts from 10:00.
+	setFakeTime(start.Add(59 * time.Minute))
+	l = newLogger()
+	defer l.Close()
+	_, err = l.Write([]byte("after restart\n"))
+	isNil(err, t)
+	equals(start, l.lastRotationTime, t)
+	fileCount(dir, 2, t) // log + state file
+
+	setFakeTime(start.Add(time.Hour))
+	_, err = l.Write([]byte("rotated\n"))
+	isNil(err, t)
+	existsWithContent(backupFileWithReason(dir, "time"), []byte("before restart\nafter restart\n"), t)
+	existsWithContent(filename, []byte("rotated\n"), t)
+
+	// The state file tracks the new rotation and isn't mistaken for a backup.
+	data, err := os.ReadFile(filename + ".state")
+	isNil(err, t)
+	var state loggerState
+	isNil(json.Unmarshal(data, &state), t)
+	equals(true, state.LastRotationTime.Equal(start.Add(time.Hour)), t)
+	backups, err := l.Backups()
+	isNil(err, t)
+	equals(1, len(backups), t)
+}
+
+func TestWithoutPersistStateRestartResetsInterval(t *testing.T) {
+	currentTime = fakeTime
+	dir := makeTempDir("TestWithoutPersistStateRestartResetsInterval", t)
+	defer os.RemoveAll(dir)
+
+	filename := logFile(dir)
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	setFakeTime(start)
+	l := &Logger{Filename: filename, RotationInterval: time.Hour}
+	_, err := l.Write([]byte("before restart\n"))
+	isNil(err, t)
+	isNil(l.Close(), t)
+
+	setFakeTime(start.Add(59 * time.Minute))
+	l = &Logger{Filename: filename, RotationInterval: time.Hour}
+	defer l.Close()
+	_, err = l.Write([]byte("after restart\n"))
+	isNil(err, t)
+	setFakeTime(start.Add(time.Hour))
+	_, err = l.Write([]byte("not rotated\n"))
+	isNil(err, t)
+	fileCount(dir, 1, t)
+	notExist(filename+".state", t)
+}
+
+func TestOnCleanup(t *testing.T) {
+	dir := t.TempDir()
+
+	var calls int
+	var removed, compressed []string
+	l := &Logger{
+		Filename:   filepath.Join(dir, "foobar.log"),
+		MaxBackups: 2,
+		Compress:   true,
+		OnCleanup: func(r, c []string) {
+			calls++
+			removed, compressed = r, c
+		},
+	}
+
+	names := []string{
+		"foobar-2025-01-01T00-00-00.000-size.log",
+		"foobar-2025-01-02T00-00-00.000-size.log.gz",
+		"foobar-2025-01-03T00-00-00.000-time.log.gz",
+		"foobar-2025-01-04T00-00-00.000-size.log",
+	}
+	for _, name := range names {
+		isNil(os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644), t)
+	}
+
+	isNil(l.millRunOnce(), t)
+	equals(1, calls, t)
+	equals([]string{filepath.Join(dir, names[0]), filepath.Join(dir, names[1])}, removed, t)
+	equals([]string{filepath.Join(dir, names[3])}, compressed, t)
+
+	// A run with nothing to do still reports, with empty lists.
+	isNil(l.millRunOnce(), t)
+	equals(2, calls, t)
+	equals(0, len(removed), t)
+	equals(0, len(compressed), t)
+}
+
+// fakeUploader records the backups it is asked to upload, failing with err.
+type fakeUploader struct {
+	uploads []string
+	err     error
+	t       *testing.T
+}
+
+func (u *fakeUploader) Upload(ctx context.Context, localPath string) error {
+	exists(localPath, u.t) // The backup must still be there while it's uploaded.
+	u.uploads = append(u.uploads, localPath)
+	return u.err
+}
+
+func TestUploader(t *testing.T) {
+	dir := t.TempDir()
+	u := &fakeUploader{t: t}
+	l := &Logger{
+		Filename:          filepath.Join(dir, "foobar.log"),
+		Compress:          true,
+		Uploader:          u,
+		RemoveAfterUpload: true,
+	}
+
+	names := []string{
+		"foobar-2025-01-01T00-00-00.000-size.log.gz",
+		"foobar-2025-01-02T00-00-00.000-size.log",
+	}
+	for _, name := range names {
+		isNil(os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644), t)
+	}
+
+	// Oldest first, and only once compressed.
+	isNil(l.millRunOnce(), t)
+	equals([]string{filepath.Join(dir, names[0]), filepath.Join(dir, names[1]+compressSuffix)}, u.uploads, t)
+	fileCount(dir, 0, t)
+}
+
+func TestUploaderKeepsBackups(t *testing.T) {
+	dir := t.TempDir()
+	u := &fakeUploader{t: t}
+	l := &Logger{Filename: filepath.Join(dir, "foobar.log"), Uploader: u}
+
+	backup := filepath.Join(dir, "foobar-2025-01-01T00-00-00.000-size.log")
+	isNil(os.WriteFile(backup, []byte("x"), 0644), t)
+
+	// Without RemoveAfterUpload the backup stays, and is uploaded only once.
+	isNil(l.millRunOnce(), t)
+	isNil(l.millRunOnce(), t)
+	equals([]string{backup}, u.uploads, t)
+	existsWithContent(backup, []byte("x"), t)
+	equals(1, len(l.uploaded), t)
+
+	// Once the backup is gone, it is forgotten.
+	isNil(os.Remove(backup), t)
+	isNil(l.millRunOnce(), t)
+	equals(0, len(l.uploaded), t)
+}
+
+func TestUploaderFailureKeepsBackup(t *testing.T) {
+	dir := t.TempDir()
+	u := &fakeUploader{t: t, err: errors.New("bucket unreachable")}
+	var reported []error
+	l := &Logger{
+		Filename:          filepath.Join(dir, "foobar.log"),
+		Uploader:          u,
+		RemoveAfterUpload: true,
+		OnRotationError:   func(err error) { reported = append(reported, err) },
+	}
+
+	backup := filepath.Join(dir, "foobar-2025-01-01T00-00-00.000-size.log")
+	isNil(os.WriteFile(backup, []byte("x"), 0644), t)
+
+	isNil(l.millRunOnce(), t)
+	existsWithContent(backup, []byte("x"), t)
+	equals(1, len(reported), t)
+	assert(errors.Is(reported[0], u.err), t, "expected the upload error, got %v", reported[0])
+
+	// The next cleanup tries again.
+	u.err = nil
+	isNil(l.millRunOnce(), t)
+	equals([]string{backup, backup}, u.uploads, t)
+	notExist(backup, t)
+}
+
+func TestCatchUpMissedRotations(t *testing.T) {
+	for _, catchUp := range []bool{false, true} {
+		t.Run(fmt.Sprintf("catchUp=%v", catchUp), func(t *testing.T) {
+			currentTime = fakeTime
+			dir := t.TempDir()
+			filename := filepath.Join(dir, "foobar.log")
+
+			l := &Logger{
+				Filename:               filename,
+				RotateAtMinutes:        []int{0},
+				CatchUpMissedRotations: catchUp,
+			}
+			defer l.Close()
+
+			// The last rotation happened at 10:50; then the process "slept" through
+			// the 11:00 and 12:00 marks and woke up at 12:10.
+			setFakeTime(time.Date(2025, 6, 1, 10, 50, 0, 0, time.UTC))
+			l.mu.Lock()
+			isNil(l.openNew("initial"), t)
+			_, err := l.file.Write([]byte("before sleep\n"))
+			isNil(err, t)
+			l.lastRotationTime = fakeCurrentTime
+			setFakeTime(time.Date(2025, 6, 1, 12, 10, 0, 0, time.UTC))
+			l.ensureScheduledRotationLoopRunning()
+			l.mu.Unlock()
+
+			time.Sleep(100 * time.Millisecond)
+
+			if !catchUp {
+				fileCount(dir, 1, t)
+				return
+			}
+			// Exactly one catch-up rotation, not one per missed mark.
+			existsWithContent(backupFileWithReason(dir, "time"), []byte("before sleep\n"), t)
+			fileCount(dir, 2, t)
+			l.mu.Lock()
+			equals(fakeCurrentTime, l.lastRotationTime, t)
+			l.mu.Unlock()
+		})
+	}
+}
+
+func TestLastScheduledMark(t *testing.T) {
+	l := &Logger{processedRotateAtMinutes: []int{15, 45}}
+
+	mark, ok := l.lastScheduledMark(time.Date(2025, 6, 1, 12, 30, 0, 0, time.UTC))
+	equals(true, ok, t)
+	equals(time.Date(2025, 6, 1, 12, 15, 0, 0, time.UTC), mark, t)
+
+	mark, ok = l.lastScheduledMark(time.Date(2025, 6, 1, 12, 10, 0, 0, time.UTC))
+	equals(true, ok, t)
+	equals(time.Date(2025, 6, 1, 11, 45, 0, 0, time.UTC), mark, t)
+
+	mark, ok = l.lastScheduledMark(time.Date(2025, 6, 1, 12, 45, 0, 0, time.UTC))
+	equals(true, ok, t)
+	equals(time.Date(2025, 6, 1, 12, 45, 0, 0, time.UTC), mark, t)
+}
+
+func TestLastScheduledMarkWeekdays(t *testing.T) {
+	l := &Logger{processedRotateAtMinutes: []int{30}, RotateAtTimeOfDay: 2 * time.Hour, hasWeekdaySchedule: true}
+	l.processedRotateOnWeekdays[time.Sunday] = true
+
+	// 2025-06-01 is a Sunday. On Monday, the last mark is the hourly one.
+	mark, ok := l.lastScheduledMark(time.Date(2025, 6, 2, 12, 40, 0, 0, time.UTC))
+	equals(true, ok, t)
+	equals(time.Date(2025, 6, 2, 12, 30, 0, 0, time.UTC), mark, t)
+
+	// Between the Sunday 02:00 slot and the next hourly mark.
+	mark, ok = l.lastScheduledMark(time.Date(2025, 6, 1, 2, 10, 0, 0, time.UTC))
+	equals(true, ok, t)
+	equals(time.Date(2025, 6, 1, 2, 0, 0, 0, time.UTC), mark, t)
+
+	// Weekdays only: the last Sunday, up to a week back.
+	l.processedRotateAtMinutes = nil
+	mark, ok = l.lastScheduledMark(time.Date(2025, 6, 7, 23, 0, 0, 0, time.UTC))
+	equals(true, ok, t)
+	equals(time.Date(2025, 6, 1, 2, 0, 0, 0, time.UTC), mark, t)
+	mark, ok = l.lastScheduledMark(time.Date(2025, 6, 8, 1, 0, 0, 0, time.UTC))
+	equals(true, ok, t)
+	equals(time.Date(2025, 6, 1, 2, 0, 0, 0, time.UTC), mark, t)
+}
+
+func TestCatchUpMissedWeekdayRotation(t *testing.T) {
+	clock := &manualClock{now: time.Date(2025, 5, 30, 18, 0, 0, 0, time.UTC)} // a Friday
+	dir := t.TempDir()
+	filename := logFile(dir)
+	l := &Logger{
+		Filename:               filename,
+		Clock:                  clock,
+		RotateOnWeekdays:       []time.Weekday{time.Sunday},
+		RotateAtTimeOfDay:      2 * time.Hour,
+		CatchUpMissedRotations: true,
+	}
+	defer l.Close()
+	_, err := l.Write([]byte("before the weekend\n"))
+	isNil(err, t)
+
+	// Nothing was missed yet.
+	l.catchUpMissedRotation()
+	fileCount(dir, 1, t)
+
+	// The process was down over Sunday 02:00 and is back on Monday.
+	clock.Advance(3 * 24 * time.Hour)
+	l.catchUpMissedRotation()
+	existsWithContent(filepath.Join(dir, "foobar-2025-06-02T18-00-00.000-time.log"), []byte("before the weekend\n"), t)
+	fileCount(dir, 2, t)
+
+	// Once caught up, the same mark doesn't rotate again.
+	l.catchUpMissedRotation()
+	fileCount(dir, 2, t)
+}
+
+func TestSkipInitialMill(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+
+	var scans int32
+	origReadDir := osReadDir
+	osReadDir = func(name string) ([]os.DirEntry, error) {
+		atomic.AddInt32(&scans, 1)
+		return os.ReadDir(name)
+	}
+	defer func() { osReadDir = origReadDir }()
+
+	filename := filepath.Join(dir, "foobar.log")
+	isNil(os.WriteFile(filename, []byte("existing\n"), 0644), t)
+	l := &Logger{
+		Filename:        filename,
+		MaxBackups:      1,
+		Compress:        true,
+		SkipInitialMill: true,
+	}
+	defer l.Close()
+
+	_, err := l.Write([]byte("first\n"))
+	isNil(err, t)
+	time.Sleep(50 * time.Millisecond)
+	equals(int32(0), atomic.LoadInt32(&scans), t)
+
+	// The first rotation runs the deferred cleanup.
+	isNil(l.Rotate(), t)
+	time.Sleep(50 * time.Millisecond)
+	equals(int32(1), atomic.LoadInt32(&scans), t)
+}
+
+func TestRotateOnStart(t *testing.T) {
+	for _, reason := range []string{"", "boot"} {
+		t.Run("reason="+reason, func(t *testing.T) {
+			currentTime = fakeTime
+			dir := t.TempDir()
+			filename := filepath.Join(dir, "foobar.log")
+			previous := []byte("previous run\n")
+			isNil(os.WriteFile(filename, previous, 0644), t)
+
+			l := &Logger{
+				Filename:              filename,
+				RotateOnStart:         true,
+				StartupRotationReason: reason,
+			}
+			defer l.Close()
+
+			b := []byte("this run\n")
+			_, err := l.Write(b)
+			isNil(err, t)
+
+			want := reason
+			if want == "" {
+				want = "start"
+			}
+			existsWithContent(backupFileWithReason(dir, want), previous, t)
+			existsWithContent(filename, b, t)
+			fileCount(dir, 2, t)
+		})
+	}
+}
+
+func TestRotateOnStartSkipsEmptyFile(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "foobar.log")
+	isNil(os.WriteFile(filename, nil, 0644), t)
+
+	l := &Logger{Filename: filename, RotateOnStart: true}
+	defer l.Close()
+	b := []byte("this run\n")
+	_, err := l.Write(b)
+	isNil(err, t)
+	existsWithContent(filename, b, t)
+	fileCount(dir, 1, t)
+}
+
+func TestSizeOnlyWriteSkipsClock(t *testing.T) {
+	var clockReads int
+	currentTime = func() time.Time {
+		clockReads++
+		return fakeTime()
+	}
+	defer func() { currentTime = fakeTime }()
+
+	dir := t.TempDir()
+	l := &Logger{Filename: filepath.Join(dir, "foobar.log")}
+	defer l.Close()
+
+	_, err := l.Write([]byte("first\n")) // opens the file, which reads the clock once
+	isNil(err, t)
+	reads := clockReads
+	for i := 0; i < 10; i++ {
+		_, err = l.Write([]byte("more\n"))
+		isNil(err, t)
+	}
+	equals(reads, clockReads, t)
+
+	l.RotationInterval = time.Hour
+	_, err = l.Write([]byte("timed\n"))
+	isNil(err, t)
+	assert(clockReads > reads, t, "expected the clock to be read once a time trigger is set")
+}
+
+func benchmarkWrite(b *testing.B, l *Logger) {
+	defer l.Close()
+	line := []byte("2025-01-01T00:00:00Z INFO request served path=/api/v1/items status=200\n")
+	b.SetBytes(int64(len(line)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := l.Write(line); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteSizeOnly(b *testing.B) {
+	currentTime = time.Now
+	defer func() { currentTime = fakeTime }()
+	benchmarkWrite(b, &Logger{Filename: filepath.Join(b.TempDir(), "bench.log"), MaxSize: 1 << 20})
+}
+
+func BenchmarkWriteWithInterval(b *testing.B) {
+	currentTime = time.Now
+	defer func() { currentTime = fakeTime }()
+	benchmarkWrite(b, &Logger{Filename: filepath.Join(b.TempDir(), "bench.log"), MaxSize: 1 << 20, RotationInterval: 24 * time.Hour})
+}
+
+func BenchmarkWriteWithStatsScraper(b *testing.B) {
+	currentTime = time.Now
+	defer func() { currentTime = fakeTime }()
+	l := &Logger{Filename: filepath.Join(b.TempDir(), "bench.log"), MaxSize: 1 << 20}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				_ = l.Stats()
+			}
+		}
+	}()
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+	benchmarkWrite(b, l)
+}
+
+func TestStatsDoesNotBlockOnWrite(t *testing.T) {
+	l := &Logger{Filename: logFile(t.TempDir())}
+	defer l.Close()
+
+	// Stats must return while a write holds the lock.
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	got := make(chan Stats, 1)
+	go func() { got <- l.Stats() }()
+	select {
+	case s := <-got:
+		equals(int64(0), s.MillErrors, t)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stats blocked on the logger's lock")
+	}
+}
+
+func TestReopenCacheTTL(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "foobar.log")
+	l := &Logger{
+		Filename:       filename,
+		ReopenCacheTTL: 100 * time.Millisecond,
+	}
+
+	_, err := l.Write([]byte("open\n"))
+	isNil(err, t)
+	isNil(l.Close(), t)
+
+	_, err = l.Write([]byte("after close 1\n"))
+	isNil(err, t)
+	l.mu.Lock()
+	cached := l.closedFile
+	l.mu.Unlock()
+	notNil(cached, t)
+
+	_, err = l.Write([]byte("after close 2\n"))
+	isNil(err, t)
+	l.mu.Lock()
+	equals(cached, l.closedFile, t) // same descriptor reused within the TTL
+	l.mu.Unlock()
+	existsWithContent(filename, []byte("open\nafter close 1\nafter close 2\n"), t)
+
+	// Once idle for the TTL, the descriptor is released.
+	time.Sleep(250 * time.Millisecond)
+	l.mu.Lock()
+	isNil(l.closedFile, t)
+	l.mu.Unlock()
+
+	// A later write opens a new one, which a second Close releases at once.
+	_, err = l.Write([]byte("after close 3\n"))
+	isNil(err, t)
+	isNil(l.Close(), t)
+	l.mu.Lock()
+	isNil(l.closedFile, t)
+	l.mu.Unlock()
+	existsWithContent(filename, []byte("open\nafter close 1\nafter close 2\nafter close 3\n"), t)
+}
+
+func TestOnRotateReason(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+
+	type rotation struct {
+		reason RotationReason
+		backup string
+	}
+	record := func(got *[]rotation) func(RotationReason, string) {
+		return func(reason RotationReason, backupPath string) {
+			*got = append(*got, rotation{reason, backupPath})
+		}
+	}
+
+	t.Run("size", func(t *testing.T) {
+		dir := t.TempDir()
+		var got []rotation
+		l := &Logger{Filename: logFile(dir), MaxSize: 10, OnRotate: record(&got)}
+		defer l.Close()
+
+		_, err := l.Write([]byte("boo!"))
+		isNil(err, t)
+		_, err = l.Write([]byte("foooooo!"))
+		isNil(err, t)
+		equals([]rotation{{ReasonSize, backupFileWithReason(dir, "size")}}, got, t)
+	})
+
+	t.Run("time", func(t *testing.T) {
+		dir := t.TempDir()
+		var got []rotation
+		l := &Logger{Filename: logFile(dir), RotationInterval: time.Hour, OnRotate: record(&got)}
+		defer l.Close()
+
+		_, err := l.Write([]byte("boo!"))
+		isNil(err, t)
+		newFakeTime()
+		_, err = l.Write([]byte("foo!"))
+		isNil(err, t)
+		equals(1, len(got), t)
+		equals(ReasonTime, got[0].reason, t)
+	})
+
+	t.Run("manual", func(t *testing.T) {
+		dir := t.TempDir()
+		var got []rotation
+		l := &Logger{Filename: logFile(dir), OnRotate: record(&got)}
+		defer l.Close()
+
+		_, err := l.Write([]byte("boo!"))
+		isNil(err, t)
+		isNil(l.Rotate(), t)
+		dest := filepath.Join(dir, "harvested.log")
+		isNil(l.RotateTo(dest), t)
+		equals([]rotation{
+			{ReasonManual, backupFileWithReason(dir, "size")},
+			{ReasonManual, dest},
+		}, got, t)
+	})
+
+	t.Run("startup", func(t *testing.T) {
+		dir := t.TempDir()
+		var got []rotation
+		isNil(os.WriteFile(logFile(dir), []byte("previous run\n"), 0644), t)
+		l := &Logger{Filename: logFile(dir), RotateOnStart: true, OnRotate: record(&got)}
+		defer l.Close()
+
+		_, err := l.Write([]byte("boo!"))
+		isNil(err, t)
+		equals([]rotation{{ReasonStartup, backupFileWithReason(dir, "start")}}, got, t)
+	})
+}
+
+func TestRotationReasonString(t *testing.T) {
+	equals("size", ReasonSize.String(), t)
+	equals("time", ReasonTime.String(), t)
+	equals("manual", ReasonManual.String(), t)
+	equals("startup", ReasonStartup.String(), t)
+	equals("RotationReason(0)", RotationReason(0).String(), t)
+}
+
+func TestNextWeekdaySlot(t *testing.T) {
+	l := &Logger{
+		Filename:          logFile(t.TempDir()),
+		RotateOnWeekdays:  []time.Weekday{time.Sunday},
+		RotateAtTimeOfDay: 2 * time.Hour,
+	}
+	l.ensureScheduledRotationLoopRunning()
+	defer l.Close()
+
+	sunday2am := time.Date(2025, time.June, 8, 2, 0, 0, 0, time.UTC) // a Sunday
+	tests := []struct {
+		name string
+		now  time.Time
+		want time.Time
+	}{
+		{"midweek", time.Date(2025, time.June, 4, 10, 0, 0, 0, time.UTC), sunday2am},
+		{"saturday night", time.Date(2025, time.June, 7, 23, 59, 0, 0, time.UTC), sunday2am},
+		{"sunday before slot", time.Date(2025, time.June, 8, 1, 0, 0, 0, time.UTC), sunday2am},
+		{"sunday at slot", sunday2am, sunday2am.AddDate(0, 0, 7)},
+		{"sunday past slot", time.Date(2025, time.June, 8, 3, 0, 0, 0, time.UTC), sunday2am.AddDate(0, 0, 7)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := l.nextWeekdaySlot(tt.now)
+			assert(ok, t, "expected a slot")
+			equals(tt.want, got, t)
+		})
+	}
+}
+
+func TestNextWeekdaySlotMultipleDays(t *testing.T) {
+	l := &Logger{
+		Filename:         logFile(t.TempDir()),
+		RotateOnWeekdays: []time.Weekday{time.Friday, time.Monday, time.Weekday(9)},
+	}
+	l.ensureScheduledRotationLoopRunning()
+	defer l.Close()
+
+	// Tuesday: next is Friday midnight; the invalid weekday is ignored.
+	got, ok := l.nextWeekdaySlot(time.Date(2025, time.June, 3, 12, 0, 0, 0, time.UTC))
+	assert(ok, t, "expected a slot")
+	equals(time.Date(2025, time.June, 6, 0, 0, 0, 0, time.UTC), got, t)
+
+	// Saturday: next is Monday midnight.
+	got, ok = l.nextWeekdaySlot(time.Date(2025, time.June, 7, 12, 0, 0, 0, time.UTC))
+	assert(ok, t, "expected a slot")
+	equals(time.Date(2025, time.June, 9, 0, 0, 0, 0, time.UTC), got, t)
+}
+
+func TestNextScheduledRotationDaysAway(t *testing.T) {
+	l := &Logger{
+		Filename:          logFile(t.TempDir()),
+		RotateOnWeekdays:  []time.Weekday{time.Saturday},
+		RotateAtTimeOfDay: 23 * time.Hour,
+	}
+	l.ensureScheduledRotationLoopRunning()
+	defer l.Close()
+
+	// Sunday just after the slot: the next one is six days away.
+	got, ok := l.nextScheduledRotation(time.Date(2025, time.June, 8, 0, 30, 0, 0, time.UTC))
+	assert(ok, t, "expected a slot")
+	equals(time.Date(2025, time.June, 14, 23, 0, 0, 0, time.UTC), got, t)
+}
+
+func TestNextScheduledRotationPicksEarliest(t *testing.T) {
+	l := &Logger{
+		Filename:         logFile(t.TempDir()),
+		RotateAtMinutes:  []int{45, 15},
+		RotateOnWeekdays: []time.Weekday{time.Monday},
+	}
+	l.ensureScheduledRotationLoopRunning()
+	defer l.Close()
+
+	// Sunday 23:50: Monday midnight comes before the 00:15 minute mark.
+	got, ok := l.nextScheduledRotation(time.Date(2025, time.June, 8, 23, 50, 0, 0, time.UTC))
+	assert(ok, t, "expected a slot")
+	equals(time.Date(2025, time.June, 9, 0, 0, 0, 0, time.UTC), got, t)
+
+	// Monday 00:20: the next minute mark is 00:45.
+	got, ok = l.nextScheduledRotation(time.Date(2025, time.June, 9, 0, 20, 0, 0, time.UTC))
+	assert(ok, t, "expected a slot")
+	equals(time.Date(2025, time.June, 9, 0, 45, 0, 0, time.UTC), got, t)
+}
+
+func TestMaxFileAgeRotatesIdleFile(t *testing.T) {
+	currentTime = fakeTime
+	origInterval := fileAgeCheckInterval
+	fileAgeCheckInterval = 10 * time.Millisecond
+	defer func() { fileAgeCheckInterval = origInterval }()
+
+	dir := t.TempDir()
+	filename := logFile(dir)
+	l := &Logger{
+		Filename:   filename,
+		MaxFileAge: time.Hour,
+	}
+	defer l.Close()
+
+	b := []byte("quiet log\n")
+	_, err := l.Write(b)
+	isNil(err, t)
+
+	// Younger than MaxFileAge: nothing happens.
+	time.Sleep(100 * time.Millisecond)
+	fileCount(dir, 1, t)
+
+	// Let the file age past MaxFileAge without any further writes.
+	l.mu.Lock()
+	newFakeTime()
+	l.mu.Unlock()
+	time.Sleep(200 * time.Millisecond)
+
+	existsWithContent(backupFileWithReason(dir, "time"), b, t)
+	existsWithContent(filename, []byte{}, t)
+	fileCount(dir, 2, t)
+
+	// The fresh file is empty, so it isn't rotated again even once it is old.
+	l.mu.Lock()
+	newFakeTime()
+	l.mu.Unlock()
+	time.Sleep(100 * time.Millisecond)
+	fileCount(dir, 2, t)
+}
+
+func TestEffectiveBackupTimeFormat(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured string
+		want       string
+	}{
+		{"valid", "2006-01-02-15-04-05", "2006-01-02-15-04-05"},
+		{"empty", "", backupTimeFormat},
+		{"invalid", "not-a-time-format", backupTimeFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Logger{Filename: logFile(t.TempDir()), BackupTimeFormat: tt.configured}
+			defer l.Close()
+			equals(tt.want, l.EffectiveBackupTimeFormat(), t)
+		})
+	}
+}
+
+func TestEffectiveBackupTimeFormatMatchesBackupName(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	l := &Logger{Filename: logFile(dir), BackupTimeFormat: "bogus"}
+	defer l.Close()
+
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+	isNil(l.Rotate(), t)
+
+	// The rotation fell back to the default format, which is what's reported.
+	equals(backupTimeFormat, l.EffectiveBackupTimeFormat(), t)
+	exists(backupFileWithReason(dir, "size"), t)
+}
+
+func TestWriteMetaSidecar(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	cleaned := make(chan struct{}, 10)
+	l := &Logger{
+		Filename:         logFile(dir),
+		Compress:         true,
+		MaxBackups:       1,
+		WriteMetaSidecar: true,
+		OnCleanup:        func(_, _ []string) { cleaned <- struct{}{} },
+	}
+	defer l.Close()
+
+	first := []byte("first file\n")
+	_, err := l.Write(first)
+	isNil(err, t)
+	<-cleaned // initial cleanup on open
+	isNil(l.Rotate(), t)
+	<-cleaned
+
+	backup1 := backupFileWithReason(dir, "size")
+	meta1 := backup1 + metaSuffix
+	exists(backup1+compressSuffix, t)
+	data, err := os.ReadFile(meta1)
+	isNil(err, t)
+	var meta backupMeta
+	isNil(json.Unmarshal(data, &meta), t)
+	sum := sha256.Sum256(first)
+	equals("size", meta.Reason, t)
+	assert(meta.Timestamp.Equal(fakeTime()), t, "unexpected timestamp %v", meta.Timestamp)
+	equals(int64(len(first)), meta.Size, t)
+	equals(hex.EncodeToString(sum[:]), meta.SHA256, t)
+	equals(true, meta.Compressed, t)
+
+	// The sidecar is not mistaken for a backup.
+	backups, err := l.Backups()
+	isNil(err, t)
+	equals(1, len(backups), t)
+
+	// When the backup is removed by MaxBackups, its sidecar goes with it.
+	newFakeTime()
+	_, err = l.Write([]byte("second file\n"))
+	isNil(err, t)
+	isNil(l.Rotate(), t)
+	<-cleaned
+
+	notExist(backup1+compressSuffix, t)
+	notExist(meta1, t)
+	backup2 := backupFileWithReason(dir, "size")
+	exists(backup2+compressSuffix, t)
+	exists(backup2+metaSuffix, t)
+	fileCount(dir, 3, t)
+}
+
+func TestNormalizeNewlines(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := t.TempDir()
+	filename := logFile(dir)
+	l := &Logger{Filename: filename, MaxSize: 20, NormalizeNewlines: true}
+	defer l.Close()
+
+	b := []byte("one\r\ntwo\nthree\r\n")
+	n, err := l.Write(b)
+	isNil(err, t)
+	equals(len(b), n, t) // all of p was consumed
+	existsWithContent(filename, []byte("one\ntwo\nthree\n"), t)
+	equals(int64(14), l.size, t) // size counts the normalized bytes
+
+	// 14 + 6 normalized bytes fit in MaxSize; the 7 raw bytes wouldn't.
+	b = []byte("four\r\n\r")
+	n, err = l.Write(b)
+	isNil(err, t)
+	equals(len(b), n, t)
+	equals(int64(20), l.size, t)
+	existsWithContent(filename, []byte("one\ntwo\nthree\nfour\n\r"), t)
+	fileCount(dir, 1, t)
+}
+
+func TestOriginalLen(t *testing.T) {
+	p := []byte("a\r\nb\r\r\n")
+	// normalized: "a\nb\r\n"
+	for n, want := range []int{0, 1, 3, 4, 5, 7} {
+		equals(want, originalLen(p, n), t)
+	}
+}
+
+func TestOnRotateCompletesBeforeWriteReturns(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := t.TempDir()
+
+	var queue []string
+	l := &Logger{
+		Filename: logFile(dir),
+		MaxSize:  10,
+		OnRotate: func(_ RotationReason, backupPath string) {
+			time.Sleep(50 * time.Millisecond) // a slow consumer must still finish first
+			queue = append(queue, backupPath)
+		},
+	}
+	defer l.Close()
+
+	first := []byte("boo!")
+	_, err := l.Write(first)
+	isNil(err, t)
+	equals(0, len(queue), t)
+
+	for i := 0; i < 3; i++ {
+		newFakeTime()
+		_, err = l.Write([]byte("foooooo!"))
+		isNil(err, t)
+		// The backup made by this very Write is already queued, in order.
+		equals(i+1, len(queue), t)
+		equals(backupFileWithReason(dir, "size"), queue[i], t)
+		exists(queue[i], t)
+	}
+	existsWithContent(queue[0], first, t)
+}
+
+func TestOnRotateInRotationOrder(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := t.TempDir()
+
+	queue := make(chan string, 3)
+	l := &Logger{
+		Filename: logFile(dir),
+		MaxSize:  10,
+		OnRotate: func(_ RotationReason, backupPath string) {
+			time.Sleep(10 * time.Millisecond) // a slow consumer doesn't reorder the calls
+			queue <- backupPath
+		},
+	}
+	defer l.Close()
+
+	first := []byte("boo!")
+	_, err := l.Write(first)
+	isNil(err, t)
+
+	var backups []string
+	for i := 0; i < 3; i++ {
+		newFakeTime()
+		_, err = l.Write([]byte("foooooo!"))
+		isNil(err, t)
+		backups = append(backups, backupFileWithReason(dir, "size"))
+	}
+	for _, backup := range backups {
+		equals(backup, <-queue, t)
+		exists(backup, t)
+	}
+	existsWithContent(backups[0], first, t)
+}
+
+func TestOnRotateWaitsForEarlierHook(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var calls []string
+	l := &Logger{
+		Filename: logFile(dir),
+		OnRotate: func(_ RotationReason, backupPath string) {
+			mu.Lock()
+			first := len(calls) == 0
+			calls = append(calls, backupPath)
+			mu.Unlock()
+			if first {
+				close(entered)
+				<-release
+			}
+		},
+	}
+	defer l.Close()
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+
+	newFakeTime()
+	firstDone := make(chan error, 1)
+	go func() { firstDone <- l.Rotate() }()
+	<-entered
+
+	// Another goroutine rotates while the first call is still running.
+	newFakeTime()
+	secondDone := make(chan error, 1)
+	go func() { secondDone <- l.Rotate() }()
+	select {
+	case <-secondDone:
+		t.Fatal("Rotate returned before OnRotate was called for its rotation")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	isNil(<-firstDone, t)
+	isNil(<-secondDone, t)
+	mu.Lock()
+	defer mu.Unlock()
+	equals(2, len(calls), t)
+}
+
+func TestMaxFilesInDir(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	filename := logFile(dir)
+	isNil(os.WriteFile(filename, []byte("active"), 0644), t)
+
+	var names []string
+	for day := 1; day <= 6; day++ {
+		name := fmt.Sprintf("foobar-2025-01-%02dT00-00-00.000-size.log", day)
+		names = append(names, name)
+		isNil(os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644), t)
+	}
+	// Unrelated files don't count towards the cap and are never touched.
+	isNil(os.WriteFile(filepath.Join(dir, "other.txt"), []byte("x"), 0644), t)
+
+	l := &Logger{Filename: filename, MaxFilesInDir: 4}
+	isNil(l.millRunOnce(), t)
+
+	// The active file plus the three newest backups.
+	for _, name := range names[:3] {
+		notExist(filepath.Join(dir, name), t)
+	}
+	for _, name := range names[3:] {
+		exists(filepath.Join(dir, name), t)
+	}
+	exists(filepath.Join(dir, "other.txt"), t)
+	fileCount(dir, 5, t)
+}
+
+func TestMaxFilesInDirCountsSidecars(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	filename := logFile(dir)
+	isNil(os.WriteFile(filename, []byte("active"), 0644), t)
+
+	var names []string
+	for day := 1; day <= 4; day++ {
+		name := fmt.Sprintf("foobar-2025-01-%02dT00-00-00.000-size.log", day)
+		names = append(names, name)
+		isNil(os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644), t)
+		isNil(os.WriteFile(filepath.Join(dir, name+metaSuffix), []byte("{}"), 0644), t)
+		isNil(os.WriteFile(filepath.Join(dir, name+indexSuffix), []byte{0}, 0644), t)
+	}
+
+	l := &Logger{Filename: filename, MaxFilesInDir: 7, WriteMetaSidecar: true, BuildLineIndex: true}
+	isNil(l.millRunOnce(), t)
+
+	// The active file plus the two newest backups with their sidecars and indexes.
+	for _, name := range names[:2] {
+		notExist(filepath.Join(dir, name), t)
+		notExist(filepath.Join(dir, name+metaSuffix), t)
+		notExist(filepath.Join(dir, name+indexSuffix), t)
+	}
+	for _, name := range names[2:] {
+		exists(filepath.Join(dir, name), t)
+		exists(filepath.Join(dir, name+metaSuffix), t)
+		exists(filepath.Join(dir, name+indexSuffix), t)
+	}
+	fileCount(dir, 7, t)
+}
+
+func TestSizeFromStat(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	origOnDiskSize := onDiskSize
+	defer func() { onDiskSize = origOnDiskSize }()
+
+	tests := []struct {
+		name       string
+		onDisk     func(os.FileInfo) int64
+		wantRotate bool
+	}{
+		// 4:1 transparent compression: 8 bytes written only take 2 on disk.
+		{"compressed", func(info os.FileInfo) int64 { return info.Size() / 4 }, false},
+		// Preallocation: the file takes up more space than was written.
+		{"preallocated", func(info os.FileInfo) int64 { return info.Size() + 4 }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			onDiskSize = tt.onDisk
+			dir := t.TempDir()
+			l := &Logger{Filename: logFile(dir), MaxSize: 12, SizeFromStat: true}
+			defer l.Close()
+
+			_, err := l.Write([]byte("12345678"))
+			isNil(err, t)
+			// By bytes written, the second write fits and the third doesn't; the stat size decides instead.
+			_, err = l.Write([]byte("abcd"))
+			isNil(err, t)
+			_, err = l.Write([]byte("efgh"))
+			isNil(err, t)
+
+			if tt.wantRotate {
+				fileCount(dir, 2, t)
+			} else {
+				existsWithContent(logFile(dir), []byte("12345678abcdefgh"), t)
+				fileCount(dir, 1, t)
+			}
+		})
+	}
+}
+
+func TestMinFreeBytes(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	free := int64(1000)
+	var calls int
+	origFreeBytes := freeBytes
+	freeBytes = func(string) (int64, error) {
+		calls++
+		return free, nil
+	}
+	defer func() { freeBytes = origFreeBytes }()
+
+	dir := t.TempDir()
+	filename := logFile(dir)
+	l := &Logger{Filename: filename, MaxSize: 10, MinFreeBytes: 500}
+	defer l.Close()
+
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+
+	// Low on space: neither the write nor the rotation it would cause happens.
+	free = 499
+	n, err := l.Write([]byte("foooooo!"))
+	assert(errors.Is(err, ErrInsufficientDiskSpace), t, "expected ErrInsufficientDiskSpace, got %v", err)
+	equals(0, n, t)
+	existsWithContent(filename, []byte("boo!"), t)
+	fileCount(dir, 1, t)
+
+	free = 500
+	_, err = l.Write([]byte("foooooo!"))
+	isNil(err, t)
+	existsWithContent(filename, []byte("foooooo!"), t)
+	fileCount(dir, 2, t)
+
+	// Without MinFreeBytes, free space isn't checked.
+	calls = 0
+	l2 := &Logger{Filename: logFile(t.TempDir())}
+	defer l2.Close()
+	_, err = l2.Write([]byte("boo!"))
+	isNil(err, t)
+	equals(0, calls, t)
+}
+
+func TestReconcileSizeOnWrite(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+
+	for _, reconcile := range []bool{false, true} {
+		t.Run(fmt.Sprintf("reconcile=%v", reconcile), func(t *testing.T) {
+			dir := t.TempDir()
+			filename := logFile(dir)
+			l := &Logger{Filename: filename, MaxSize: 10, ReconcileSizeOnWrite: reconcile}
+			defer l.Close()
+
+			_, err := l.Write([]byte("12345678"))
+			isNil(err, t)
+
+			// Truncated behind the Logger's back: the next write fits after all.
+			isNil(os.Truncate(filename, 0), t)
+			_, err = l.Write([]byte("abcd"))
+			isNil(err, t)
+			if !reconcile {
+				fileCount(dir, 2, t) // rotated on the stale size
+				return
+			}
+			existsWithContent(filename, []byte("abcd"), t)
+			equals(int64(4), l.size, t)
+
+			// Appended to behind its back: the next write no longer fits.
+			f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0)
+			isNil(err, t)
+			_, err = f.WriteString("xxxx")
+			isNil(err, t)
+			isNil(f.Close(), t)
+			_, err = l.Write([]byte("efgh"))
+			isNil(err, t)
+			existsWithContent(filename, []byte("efgh"), t)
+			fileCount(dir, 2, t)
+		})
+	}
+}
+
+func TestLastRotationReason(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := t.TempDir()
+	l := &Logger{Filename: logFile(dir), MaxSize: 10, RotationInterval: time.Hour}
+	defer l.Close()
+
+	reason, at := l.LastRotationReason()
+	equals(RotationReason(0), reason, t)
+	assert(at.IsZero(), t, "expected zero time before any rotation, got %v", at)
+
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+
+	// Size
+	_, err = l.Write([]byte("foooooo!"))
+	isNil(err, t)
+	reason, at = l.LastRotationReason()
+	equals(ReasonSize, reason, t)
+	equals(fakeTime(), at, t)
+
+	// Time
+	newFakeTime()
+	_, err = l.Write([]byte("b"))
+	isNil(err, t)
+	reason, at = l.LastRotationReason()
+	equals(ReasonTime, reason, t)
+	equals(fakeTime(), at, t)
+
+	// Manual
+	newFakeTime()
+	isNil(l.Rotate(), t)
+	reason, at = l.LastRotationReason()
+	equals(ReasonManual, reason, t)
+	equals(fakeTime(), at, t)
+}
+
+func TestDirUnavailableBackoff(t *testing.T) {
+	now := time.Date(2025, time.June, 1, 12, 0, 0, 0, time.UTC)
+	currentTime = func() time.Time { return now }
+	defer func() { currentTime = fakeTime }()
+
+	dir := t.TempDir()
+	filename := logFile(dir)
+
+	down := true
+	var opens int
+	origOpenFile := osOpenFile
+	osOpenFile = func(name string, flag int, perm os.FileMode) (*os.File, error) {
+		if name == filename {
+			opens++
+			if down {
+				return nil, errors.New("mount unavailable")
+			}
+		}
+		return origOpenFile(name, flag, perm)
+	}
+	defer func() { osOpenFile = origOpenFile }()
+
+	var reported []error
+	l := &Logger{
+		Filename:              filename,
+		DirUnavailableBackoff: time.Second,
+		BackoffBufferSize:     4,
+		OnRotationError:       func(err error) { reported = append(reported, err) },
+	}
+	defer l.Close()
+
+	// The first failure is returned and starts a 1s backoff.
+	_, err := l.Write([]byte("a"))
+	notNil(err, t)
+	equals(1, opens, t)
+	equals(1, len(reported), t)
+
+	// Within the backoff, writes are held without trying to open the file.
+	n, err := l.Write([]byte("b"))
+	isNil(err, t)
+	equals(1, n, t)
+	equals(1, opens, t)
+
+	// After it, the next failure doubles the backoff to 2s.
+	now = now.Add(time.Second)
+	_, err = l.Write([]byte("c"))
+	notNil(err, t)
+	equals(2, opens, t)
+	equals(2, len(reported), t)
+
+	now = now.Add(time.Second)
+	n, err = l.Write([]byte("dd"))
+	isNil(err, t)
+	equals(2, n, t)
+	equals(2, opens, t)
+
+	// The buffer is full, so this write is dropped.
+	_, err = l.Write([]byte("xx"))
+	equals(ErrDirUnavailable, err, t)
+
+	// Once the directory is back, held writes come first.
+	down = false
+	now = now.Add(time.Second)
+	_, err = l.Write([]byte("e"))
+	isNil(err, t)
+	equals(3, opens, t)
+	existsWithContent(filename, []byte("bdde"), t)
+	equals(int64(4), l.size, t)
+	equals(2, len(reported), t)
+}
+
+func TestDirUnavailableBackoffOnWriteError(t *testing.T) {
+	now := time.Date(2025, time.June, 1, 12, 0, 0, 0, time.UTC)
+	currentTime = func() time.Time { return now }
+	defer func() { currentTime = fakeTime }()
+
+	dir := t.TempDir()
+	filename := logFile(dir)
+
+	var opens int
+	origOpenFile := osOpenFile
+	osOpenFile = func(name string, flag int, perm os.FileMode) (*os.File, error) {
+		if name == filename {
+			opens++
+		}
+		return origOpenFile(name, flag, perm)
+	}
+	defer func() { osOpenFile = origOpenFile }()
+
+	var reported []error
+	l := &Logger{
+		Filename:              filename,
+		DirUnavailableBackoff: time.Second,
+		BackoffBufferSize:     4,
+		OnRotationError:       func(err error) { reported = append(reported, err) },
+	}
+	defer l.Close()
+
+	_, err := l.Write([]byte("a"))
+	isNil(err, t)
+	equals(1, opens, t)
+
+	// Writes to the open file start failing, as on a dead mount.
+	readOnly, err := os.Open(filename)
+	isNil(err, t)
+	l.mu.Lock()
+	isNil(l.file.Close(), t)
+	l.file = readOnly
+	l.mu.Unlock()
+
+	// The failure is returned, the file is closed and a 1s backoff starts.
+	_, err = l.Write([]byte("b"))
+	notNil(err, t)
+	equals(1, len(reported), t)
+	equals((*os.File)(nil), l.file, t)
+
+	// Within the backoff, writes are held up to BackoffBufferSize.
+	n, err := l.Write([]byte("cc"))
+	isNil(err, t)
+	equals(2, n, t)
+	_, err = l.Write([]byte("ddd"))
+	equals(ErrDirUnavailable, err, t)
+	equals(1, opens, t)
+
+	// After it, the file is reopened and the held writes come first.
+	now = now.Add(time.Second)
+	_, err = l.Write([]byte("e"))
+	isNil(err, t)
+	equals(2, opens, t)
+	existsWithContent(filename, []byte("acce"), t)
+	equals(1, len(reported), t)
+}
+
+func TestRingSize(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	filename := logFile(dir)
+	l := &Logger{Filename: filename, RingSize: 3, MaxBackups: 1, Compress: true}
+	defer l.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err := l.Write([]byte(fmt.Sprintf("file %d\n", i)))
+		isNil(err, t)
+		isNil(l.Rotate(), t)
+	}
+	<-time.After(10 * time.Millisecond) // cleanup must leave the ring alone
+
+	// Slots 0 and 1 have wrapped around and were overwritten.
+	existsWithContent(filename+".0", []byte("file 3\n"), t)
+	existsWithContent(filename+".1", []byte("file 4\n"), t)
+	existsWithContent(filename+".2", []byte("file 2\n"), t)
+	existsWithContent(filename, []byte{}, t)
+	fileCount(dir, 4, t)
+}
+
+func TestRingSizeResumesAtOldestSlot(t *testing.T) {
+	dir := t.TempDir()
+	filename := logFile(dir)
+	base := time.Now().Add(-time.Hour)
+	for i, age := range []time.Duration{2, 3, 1} { // slot 1 is the oldest
+		slot := fmt.Sprintf("%s.%d", filename, i)
+		isNil(os.WriteFile(slot, []byte("old"), 0644), t)
+		mtime := base.Add(-age * time.Minute)
+		isNil(os.Chtimes(slot, mtime, mtime), t)
+	}
+
+	l := &Logger{Filename: filename, RingSize: 3}
+	defer l.Close()
+	_, err := l.Write([]byte("new\n"))
+	isNil(err, t)
+	isNil(l.Rotate(), t)
+
+	existsWithContent(filename+".1", []byte("new\n"), t)
+	existsWithContent(filename+".0", []byte("old"), t)
+	existsWithContent(filename+".2", []byte("old"), t)
+	fileCount(dir, 4, t)
+}
+
+func TestRecognizedCompressedExts(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	names := []string{
+		"foobar-2025-01-01T00-00-00.000-size.log.zst",
+		"foobar-2025-01-02T00-00-00.000-size.log.gz",
+		"foobar-2025-01-03T00-00-00.000-size.log.zst",
+		"foobar-2025-01-04T00-00-00.000-size.log.gz",
+	}
+	for _, name := range names {
+		isNil(os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644), t)
+	}
+
+	l := &Logger{Filename: logFile(dir), MaxBackups: 2, Compress: true}
+
+	// Without configuration, .zst backups escape cleanup.
+	backups, err := l.Backups()
+	isNil(err, t)
+	equals(2, len(backups), t)
+
+	l.RecognizedCompressedExts = []string{".zst"}
+	backups, err = l.Backups()
+	isNil(err, t)
+	equals(4, len(backups), t)
+	for _, b := range backups {
+		assert(b.Compressed, t, "expected %s to be reported as compressed", b.Path)
+	}
+
+	isNil(l.millRunOnce(), t)
+	notExist(filepath.Join(dir, names[0]), t)
+	notExist(filepath.Join(dir, names[1]), t)
+	existsWithContent(filepath.Join(dir, names[2]), []byte("x"), t) // not recompressed
+	existsWithContent(filepath.Join(dir, names[3]), []byte("x"), t)
+	fileCount(dir, 2, t)
+}
+
+func TestTail(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	l := &Logger{Filename: logFile(dir)}
+	defer l.Close()
+
+	_, err := l.Write([]byte("line1\nline2\n"))
+	isNil(err, t)
+
+	r, err := l.Tail(context.Background(), 6)
+	isNil(err, t)
+	defer r.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 3; i <= 5; i++ {
+			_, err := l.Write([]byte(fmt.Sprintf("line%d\n", i)))
+			isNil(err, t)
+			time.Sleep(5 * time.Millisecond)
+		}
+		isNil(l.Rotate(), t)
+		_, err := l.Write([]byte("next file\n"))
+		isNil(err, t)
+	}()
+
+	// The tail sees the end of the existing content, then the new writes,
+	// and stops at the rotation.
+	got, err := io.ReadAll(r)
+	isNil(err, t)
+	equals("line2\nline3\nline4\nline5\n", string(got), t)
+	<-done
+}
+
+func TestTailEndsWithContext(t *testing.T) {
+	dir := t.TempDir()
+	l := &Logger{Filename: logFile(dir)}
+	defer l.Close()
+
+	// Tailing before anything was written starts out empty.
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := l.Tail(ctx, 100)
+	isNil(err, t)
+
+	_, err = l.Write([]byte("hello\n"))
+	isNil(err, t)
+	buf := make([]byte, 100)
+	n, err := r.Read(buf)
+	isNil(err, t)
+	equals("hello\n", string(buf[:n]), t)
+
+	cancel()
+	_, err = r.Read(buf)
+	equals(io.EOF, err, t)
+
+	// Writes after the tail ended are no longer delivered.
+	_, err = l.Write([]byte("more\n"))
+	isNil(err, t)
+	l.mu.Lock()
+	equals(0, len(l.tails), t)
+	l.mu.Unlock()
+}
+
+// signalWriter is an io.Writer that signals on its channel for every write.
+type signalWriter chan struct{}
+
+func (w signalWriter) Write(p []byte) (int, error) {
+	w <- struct{}{}
+	return len(p), nil
+}
+
+func TestWriteBlockedDuringScheduledRotation(t *testing.T) {
+	clock := &manualClock{now: time.Date(2025, time.June, 1, 10, 59, 30, 0, time.UTC)}
+	dir := t.TempDir()
+	var rotations int32
+	// A SyslogWriter is written to just before Write takes l.mu, which tells
+	// when the Write below is about to wait for it.
+	waiting := make(signalWriter, 2)
+	l := &Logger{
+		Filename:        logFile(dir),
+		RotateAtMinutes: []int{0},
+		Clock:           clock,
+		SyslogWriter:    waiting,
+		OnRotate:        func(RotationReason, string) { atomic.AddInt32(&rotations, 1) },
+	}
+	defer l.Close()
+
+	_, err := l.Write([]byte("before\n"))
+	isNil(err, t)
+	<-waiting
+
+	// Cross the 11:00 mark, then hold the lock as the scheduled goroutine does
+	// while a Write is waiting for it.
+	clock.Advance(35 * time.Second)
+	l.mu.Lock()
+	done := make(chan error)
+	go func() {
+		_, err := l.Write([]byte("after\n"))
+		done <- err
+	}()
+	<-waiting
+	l.scheduledRotation(time.Date(2025, time.June, 1, 11, 0, 0, 0, time.UTC))
+	l.mu.Unlock()
+	isNil(<-done, t)
+
+	// The blocked Write goes into the new file without a second rotation.
+	equals(int32(1), atomic.LoadInt32(&rotations), t)
+	existsWithContent(logFile(dir), []byte("after\n"), t)
+	fileCount(dir, 2, t)
+}
+
+func TestMaxRotationsPerMinute(t *testing.T) {
+	now := time.Date(2025, time.June, 1, 12, 0, 0, 0, time.UTC)
+	currentTime = func() time.Time { return now }
+	defer func() { currentTime = fakeTime }()
+	megabyte = 1
+
+	dir := t.TempDir()
+	var reported []error
+	l := &Logger{
+		Filename:              logFile(dir),
+		MaxSize:               5,
+		MaxRotationsPerMinute: 3,
+		OnRotationError:       func(err error) { reported = append(reported, err) },
+	}
+	defer l.Close()
+
+	write := func() {
+		_, err := l.Write([]byte("12345"))
+		isNil(err, t)
+		now = now.Add(time.Second)
+	}
+
+	// Every write after the first would rotate; only 3 may within the minute.
+	for i := 0; i < 10; i++ {
+		write()
+	}
+	fileCount(dir, 4, t) // active file + 3 backups
+	equals(int64(35), l.size, t)
+	equals(1, len(reported), t) // reported once per window
+	assert(errors.Is(reported[0], ErrRotationRateLimited), t, "unexpected error %v", reported[0])
+
+	// Once the minute is over, rotations resume.
+	now = now.Add(time.Minute)
+	write()
+	fileCount(dir, 5, t)
+	equals(int64(5), l.size, t)
+}
+
+func TestSoftMaxSize(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := t.TempDir()
+	filename := logFile(dir)
+	l := &Logger{Filename: filename, MaxSize: 10, SoftMaxSize: true}
+	defer l.Close()
+
+	// 6 + 6 bytes cross MaxSize: the second line still goes into the first file,
+	// which is then rotated.
+	_, err := l.Write([]byte("line1\n"))
+	isNil(err, t)
+	_, err = l.Write([]byte("line2\n"))
+	isNil(err, t)
+	existsWithContent(backupFileWithReason(dir, "size"), []byte("line1\nline2\n"), t)
+	existsWithContent(filename, []byte{}, t)
+
+	newFakeTime()
+	_, err = l.Write([]byte("line3\n"))
+	isNil(err, t)
+	existsWithContent(filename, []byte("line3\n"), t)
+	fileCount(dir, 2, t)
+
+	// A single write larger than MaxSize is still rejected.
+	_, err = l.Write([]byte("this is far too long\n"))
+	notNil(err, t)
+}
+
+func TestApplyRetention(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	var names []string
+	for day := 1; day <= 5; day++ {
+		name := fmt.Sprintf("foobar-2025-01-%02dT00-00-00.000-size.log", day)
+		names = append(names, name)
+		isNil(os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644), t)
+	}
+
+	l := &Logger{Filename: logFile(dir), MaxBackups: 10, Compress: true}
+	defer l.Close()
+	isNil(l.ApplyRetention(), t)
+	fileCount(dir, 5, t)
+
+	// A config reload lowers MaxBackups; it takes effect at once.
+	l.MaxBackups = 2
+	isNil(l.ApplyRetention(), t)
+	for _, name := range names[:3] {
+		notExist(filepath.Join(dir, name), t)
+	}
+	for _, name := range names[3:] {
+		// Kept, and not compressed: ApplyRetention only prunes.
+		existsWithContent(filepath.Join(dir, name), []byte("x"), t)
+	}
+	fileCount(dir, 2, t)
+}
+
+func TestBuildLineIndex(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	content := []byte("a\nbb\n\nccc")
+	older := filepath.Join(dir, "foobar-2025-01-01T00-00-00.000-size.log")
+	newer := filepath.Join(dir, "foobar-2025-01-02T00-00-00.000-size.log")
+	isNil(os.WriteFile(older, []byte("x\n"), 0644), t)
+	isNil(os.WriteFile(newer, content, 0644), t)
+
+	l := &Logger{Filename: logFile(dir), Compress: true, BuildLineIndex: true}
+
+	_, err := LineIndex(newer)
+	assert(errors.Is(err, os.ErrNotExist), t, "expected ErrNotExist before indexing, got %v", err)
+
+	isNil(l.millRunOnce(), t)
+
+	// The index is built from the (now compressed) backup's content.
+	index, err := LineIndex(newer + compressSuffix)
+	isNil(err, t)
+	equals([]int64{0, 2, 5, 6}, index, t)
+	for i, want := range []string{"a\n", "bb\n", "\n", "ccc"} {
+		end := int64(len(content))
+		if i+1 < len(index) {
+			end = index[i+1]
+		}
+		equals(want, string(content[index[i]:end]), t)
+	}
+
+	// Indexes aren't mistaken for backups and go away with theirs.
+	backups, err := l.Backups()
+	isNil(err, t)
+	equals(2, len(backups), t)
+	l.MaxBackups = 1
+	isNil(l.millRunOnce(), t)
+	notExist(older+compressSuffix, t)
+	notExist(lineIndexFilename(older), t)
+	exists(lineIndexFilename(newer), t)
+	fileCount(dir, 2, t)
+}
+
+func TestOpenExclusive(t *testing.T) {
+	dir := t.TempDir()
+	filename := logFile(dir)
+
+	first, err := OpenExclusive(filename, &Logger{MaxSize: 10})
+	isNil(err, t)
+	equals(filename, first.Filename, t)
+
+	// The same file, even under a different spelling of its path, is refused.
+	_, err = OpenExclusive(filepath.Join(dir, ".", "foobar.log"), &Logger{})
+	assert(errors.Is(err, ErrFileInUse), t, "expected ErrFileInUse, got %v", err)
+
+	// Other files are fine.
+	other, err := OpenExclusive(filepath.Join(dir, "other.log"), &Logger{})
+	isNil(err, t)
+	defer other.Close()
+
+	// Closing releases the file.
+	isNil(first.Close(), t)
+	second, err := OpenExclusive(filename, &Logger{})
+	isNil(err, t)
+	isNil(second.Close(), t)
+}
+
+func TestWriteAtTime(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	l := &Logger{Filename: logFile(dir), RotationInterval: time.Hour}
+	defer l.Close()
+
+	historical := time.Date(2020, time.March, 1, 8, 0, 0, 0, time.UTC)
+	backupAt := func(t time.Time) string {
+		return filepath.Join(dir, "foobar-"+t.Format(backupTimeFormat)+"-time.log")
+	}
+
+	writes := []struct {
+		offset time.Duration
+		line   string
+	}{
+		{0, "08:00\n"},
+		{30 * time.Minute, "08:30\n"},
+		{61 * time.Minute, "09:01\n"}, // an hour after the first write: rotates
+		{90 * time.Minute, "09:30\n"},
+		{125 * time.Minute, "10:05\n"}, // an hour after the last rotation: rotates
+	}
+	for _, w := range writes {
+		_, err := l.WriteAtTime(historical.Add(w.offset), []byte(w.line))
+		isNil(err, t)
+	}
+
+	// Backups are named after the content time, not the (fake) wall clock.
+	existsWithContent(backupAt(historical.Add(61*time.Minute)), []byte("08:00\n08:30\n"), t)
+	existsWithContent(backupAt(historical.Add(125*time.Minute)), []byte("09:01\n09:30\n"), t)
+	existsWithContent(logFile(dir), []byte("10:05\n"), t)
+	fileCount(dir, 3, t)
+
+	reason, at := l.LastRotationReason()
+	equals(ReasonTime, reason, t)
+	equals(historical.Add(125*time.Minute), at, t)
+}
+
+func TestRequireFilename(t *testing.T) {
+	_, err := New(&Logger{RequireFilename: true})
+	equals(ErrFilenameRequired, err, t)
+
+	// Without RequireFilename, an empty Filename is still allowed.
+	l, err := New(&Logger{})
+	isNil(err, t)
+	notNil(l, t)
+
+	dir := t.TempDir()
+	l, err = New(&Logger{Filename: logFile(dir), RequireFilename: true})
+	isNil(err, t)
+	defer l.Close()
+	_, err = l.Write([]byte("boo!"))
+	isNil(err, t)
+	existsWithContent(logFile(dir), []byte("boo!"), t)
+
+	// A Logger not built with New refuses to fall back to the temp directory on Write.
+	unset := &Logger{RequireFilename: true}
+	defer unset.Close()
+	_, err = unset.Write([]byte("boo!"))
+	equals(ErrFilenameRequired, err, t)
+}
+
+func TestLazyCreate(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	filename := logFile(dir)
+	l := &Logger{Filename: filename, LazyCreate: true}
+	defer l.Close()
+
+	for _, b := range [][]byte{nil, {}, []byte("\n"), []byte(" \t\n")} {
+		n, err := l.Write(b)
+		isNil(err, t)
+		equals(len(b), n, t)
+	}
+	notExist(filename, t)
+
+	// The first real write creates the file, after the held whitespace.
+	n, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+	equals(4, n, t)
+	existsWithContent(filename, []byte("\n \t\nboo!"), t)
+}
+
+func TestLazyCreateSilentLogger(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	l := &Logger{Filename: logFile(dir), LazyCreate: true}
+
+	_, err := l.Write([]byte("\n"))
+	isNil(err, t)
+	isNil(l.Close(), t)
+	fileCount(dir, 0, t)
+}
+
+func TestCompressionByReason(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	names := map[string]string{
+		"size":  "foobar-2025-01-01T00-00-00.000-size.log",
+		"time":  "foobar-2025-01-02T00-00-00.000-time.log",
+		"start": "foobar-2025-01-03T00-00-00.000-start.log",
+		"other": "foobar-2025-01-04T00-00-00.000-zstd.log",
+	}
+	for _, name := range names {
+		isNil(os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644), t)
+	}
+
+	l := &Logger{
+		Filename: logFile(dir),
+		CompressionByReason: map[string]string{
+			"size": "gzip",
+			"time": "none",
+			"zstd": "zstd", // unsupported: falls back to Compress (false)
+		},
+	}
+	isNil(l.millRunOnce(), t)
+
+	exists(filepath.Join(dir, names["size"]+compressSuffix), t)
+	notExist(filepath.Join(dir, names["size"]), t)
+	for _, reason := range []string{"time", "start", "other"} {
+		// "time" is set to "none"; the others follow Compress, which is off.
+		exists(filepath.Join(dir, names[reason]), t)
+		notExist(filepath.Join(dir, names[reason]+compressSuffix), t)
+	}
+
+	// With Compress on, only the explicit "none" stays uncompressed.
+	l.Compress = true
+	isNil(l.millRunOnce(), t)
+	exists(filepath.Join(dir, names["time"]), t)
+	exists(filepath.Join(dir, names["start"]+compressSuffix), t)
+	exists(filepath.Join(dir, names["other"]+compressSuffix), t)
+	fileCount(dir, 4, t)
+}
+
+func TestCompressionByReasonWarnsOnce(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	for day := 1; day <= 3; day++ {
+		name := fmt.Sprintf("foobar-2025-01-%02dT00-00-00.000-zstd.log", day)
+		isNil(os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644), t)
+	}
+
+	stderr, err := os.CreateTemp(t.TempDir(), "stderr")
+	isNil(err, t)
+	defer stderr.Close()
+	origStderr := os.Stderr
+	os.Stderr = stderr
+	defer func() { os.Stderr = origStderr }()
+
+	l := &Logger{
+		Filename:            logFile(dir),
+		CompressionByReason: map[string]string{"zstd": "zstd", "size": "lz4", "time": "none"},
+	}
+	// Every backup on every run falls back to Compress, but the configuration
+	// is reported only once.
+	isNil(l.millRunOnce(), t)
+	isNil(l.millRunOnce(), t)
+	os.Stderr = origStderr
+
+	out, err := os.ReadFile(stderr.Name())
+	isNil(err, t)
+	equals(1, strings.Count(string(out), `unsupported compression format "zstd" for reason "zstd"`), t)
+	equals(1, strings.Count(string(out), `unsupported compression format "lz4" for reason "size"`), t)
+	equals(2, strings.Count(string(out), "unsupported compression format"), t)
+}
+
+func TestOnStateChange(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+
+	type transition struct {
+		healthy bool
+		failed  bool // whether a failure reason was given
+	}
+	var mu sync.Mutex
+	var got []transition
+	l := &Logger{
+		Filename:               logFile(dir),
+		SkipInitialMill:        true, // keep background cleanup out of the failure count
+		UnhealthyAfterFailures: 2,
+		OnStateChange: func(healthy bool, reason string) {
+			mu.Lock()
+			got = append(got, transition{healthy, reason != ""})
+			mu.Unlock()
+		},
+	}
+	defer l.Close()
+	transitions := func() []transition {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]transition(nil), got...)
+	}
+
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+
+	origRename := osRename
+	defer func() { osRename = origRename }()
+	osRename = func(string, string) error { return errors.New("rename failed") }
+	notNil(l.Rotate(), t)
+	equals(0, len(transitions()), t) // one failure is below the threshold
+	notNil(l.Rotate(), t)
+	equals([]transition{{false, true}}, transitions(), t)
+	notNil(l.Rotate(), t)
+	equals(1, len(transitions()), t) // still unhealthy: no new transition
+
+	osRename = origRename
+	isNil(l.Rotate(), t)
+	isNil(l.Rotate(), t)
+	time.Sleep(10 * time.Millisecond) // let the cleanup runs finish
+	equals([]transition{{false, true}, {true, false}}, transitions(), t)
+}
+
+func TestOpenFlags(t *testing.T) {
+	dir := t.TempDir()
+	filename := logFile(dir)
+
+	var flags []int
+	origOpenFile := osOpenFile
+	osOpenFile = func(name string, flag int, perm os.FileMode) (*os.File, error) {
+		if name == filename {
+			flags = append(flags, flag)
+		}
+		return origOpenFile(name, flag, perm)
+	}
+	defer func() { osOpenFile = origOpenFile }()
+
+	// Creating a new file.
+	l := &Logger{Filename: filename, OpenFlags: os.O_SYNC}
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+	isNil(l.Close(), t)
+
+	// Appending to the existing one.
+	l = &Logger{Filename: filename, OpenFlags: os.O_SYNC}
+	defer l.Close()
+	_, err = l.Write([]byte("boo!"))
+	isNil(err, t)
+
+	equals([]int{
+		os.O_CREATE | os.O_WRONLY | os.O_TRUNC | os.O_SYNC,
+		os.O_APPEND | os.O_WRONLY | os.O_SYNC,
+	}, flags, t)
+	existsWithContent(filename, []byte("boo!boo!"), t)
+}
+
+func TestOpenFlagsRejected(t *testing.T) {
+	for _, flag := range []int{os.O_RDWR, os.O_TRUNC} {
+		dir := t.TempDir()
+		l := &Logger{Filename: logFile(dir), OpenFlags: flag}
+		_, err := l.Write([]byte("boo!"))
+		assert(errors.Is(err, ErrInvalidOpenFlags), t, "expected ErrInvalidOpenFlags for %#x, got %v", flag, err)
+		notExist(logFile(dir), t)
+		isNil(l.Close(), t)
+	}
+}
+
+func TestReprocess(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	var names []string
+	for day := 1; day <= 4; day++ {
+		name := fmt.Sprintf("foobar-2025-01-%02dT00-00-00.000-size.log", day)
+		names = append(names, name)
+		isNil(os.WriteFile(filepath.Join(dir, name), []byte("x\n"), 0644), t)
+	}
+
+	l := &Logger{Filename: logFile(dir)}
+	defer l.Close()
+	isNil(l.Reprocess(), t)
+	fileCount(dir, 4, t)
+
+	// The configuration is tightened on several fronts at once.
+	l.MaxBackups = 2
+	l.Compress = true
+	l.BuildLineIndex = true
+	isNil(l.Reprocess(), t)
+
+	for _, name := range names[:2] {
+		notExist(filepath.Join(dir, name), t)
+	}
+	for _, name := range names[2:] {
+		path := filepath.Join(dir, name)
+		notExist(path, t)
+		exists(path+compressSuffix, t)
+		index, err := LineIndex(path + compressSuffix)
+		isNil(err, t)
+		equals([]int64{0}, index, t)
+	}
+	fileCount(dir, 4, t) // two compressed backups and their indexes
+}
+
+func TestReprocessDuringMill(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+
+	// The mill's compression stops at creating the archive until released.
+	var inFlight, maxInFlight int32
+	entered := make(chan struct{}, 3)
+	release := make(chan struct{})
+	origOpenFile := osOpenFile
+	osOpenFile = func(name string, flag int, perm os.FileMode) (*os.File, error) {
+		if strings.HasSuffix(name, compressSuffix) {
+			n := atomic.AddInt32(&inFlight, 1)
+			for {
+				max := atomic.LoadInt32(&maxInFlight)
+				if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+					break
+				}
+			}
+			entered <- struct{}{}
+			<-release
+			atomic.AddInt32(&inFlight, -1)
+		}
+		return origOpenFile(name, flag, perm)
+	}
+	defer func() { osOpenFile = origOpenFile }()
+
+	l := &Logger{Filename: logFile(dir), Compress: true, BackupTimeFormat: backupTimeFormat}
+	defer l.Close()
+	_, err := l.Write([]byte("rotated\n"))
+	isNil(err, t)
+	isNil(l.Rotate(), t)
+	<-entered
+
+	// Neither pass runs alongside the one the rotation started.
+	done := make(chan error, 2)
+	go func() { done <- l.Reprocess() }()
+	go func() { done <- l.ApplyRetention() }()
+	select {
+	case err := <-done:
+		t.Fatalf("pass returned (%v) while the mill was compressing", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	isNil(<-done, t)
+	isNil(<-done, t)
+	equals(int32(1), atomic.LoadInt32(&maxInFlight), t)
+
+	backup := backupFileWithReason(dir, "size")
+	notExist(backup, t)
+	rc, err := OpenBackup(backup + compressSuffix)
+	isNil(err, t)
+	content, err := io.ReadAll(rc)
+	isNil(err, t)
+	isNil(rc.Close(), t)
+	equals("rotated\n", string(content), t)
+	fileCount(dir, 2, t)
+}
+
+func TestTrackLatency(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := t.TempDir()
+
+	// Every reading of the latency clock is 3ms after the previous one, as if
+	// each operation were that slow.
+	var clock time.Time
+	origLatencyNow := latencyNow
+	latencyNow = func() time.Time {
+		clock = clock.Add(3 * time.Millisecond)
+		return clock
+	}
+	defer func() { latencyNow = origLatencyNow }()
+
+	l := &Logger{Filename: logFile(dir), MaxSize: 10}
+	defer l.Close()
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+	equals(int64(0), l.Stats().WriteLatency.Count, t) // off by default
+
+	l.TrackLatency = true
+	_, err = l.Write([]byte("boo!"))
+	isNil(err, t)
+	_, err = l.Write([]byte("foooooo!")) // rotates first
+	isNil(err, t)
+
+	stats := l.Stats()
+	equals(int64(2), stats.WriteLatency.Count, t)
+	equals(6*time.Millisecond, stats.WriteLatency.Total, t)
+	equals(3*time.Millisecond, stats.WriteLatency.Max, t)
+	equals(3*time.Millisecond, stats.WriteLatency.Mean(), t)
+	equals(int64(2), stats.WriteLatency.Buckets[12], t) // 2048µs..4096µs
+	equals(3*time.Millisecond, stats.WriteLatency.Percentile(0.99), t)
+
+	equals(int64(1), stats.RotationLatency.Count, t)
+	equals(3*time.Millisecond, stats.RotationLatency.Max, t)
+}
+
+func TestLatencyStatsPercentile(t *testing.T) {
+	var h latencyHistogram
+	for i := 0; i < 9; i++ {
+		h.observe(500 * time.Nanosecond)
+	}
+	h.observe(time.Second)
+	s := h.snapshot()
+	equals(int64(9), s.Buckets[0], t)
+	equals(time.Microsecond, s.Percentile(0.5), t)
+	equals(time.Microsecond, s.Percentile(0.9), t)
+	equals(time.Second, s.Percentile(0.99), t)
+	equals(time.Duration(0), LatencyStats{}.Percentile(0.5), t)
+}
+
+func TestAlignInterval(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	filename := logFile(dir)
+	l := &Logger{
+		Filename:         filename,
+		RotationInterval: time.Hour,
+		AlignInterval:    true,
+	}
+	defer l.Close()
+
+	// Started at an arbitrary time, the first rotation waits for the top of the hour.
+	setFakeTime(time.Date(2025, 3, 1, 10, 37, 12, 0, time.UTC))
+	_, err := l.Write([]byte("first\n"))
+	isNil(err, t)
+
+	setFakeTime(time.Date(2025, 3, 1, 10, 59, 59, 0, time.UTC))
+	_, err = l.Write([]byte("second\n"))
+	isNil(err, t)
+	fileCount(dir, 1, t)
+
+	setFakeTime(time.Date(2025, 3, 1, 11, 0, 0, 0, time.UTC))
+	_, err = l.Write([]byte("third\n"))
+	isNil(err, t)
+	fileCount(dir, 2, t)
+	existsWithContent(filename, []byte("third\n"), t)
+
+	// A late write still rotates at the next boundary, not an hour after it.
+	setFakeTime(time.Date(2025, 3, 1, 12, 20, 0, 0, time.UTC))
+	_, err = l.Write([]byte("fourth\n"))
+	isNil(err, t)
+	fileCount(dir, 3, t)
+
+	setFakeTime(time.Date(2025, 3, 1, 12, 59, 0, 0, time.UTC))
+	_, err = l.Write([]byte("fifth\n"))
+	isNil(err, t)
+	fileCount(dir, 3, t)
+
+	setFakeTime(time.Date(2025, 3, 1, 13, 0, 1, 0, time.UTC))
+	_, err = l.Write([]byte("sixth\n"))
+	isNil(err, t)
+	fileCount(dir, 4, t)
+	existsWithContent(filename, []byte("sixth\n"), t)
+}
+
+func TestAlignIntervalDaily(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	filename := logFile(dir)
+	l := &Logger{
+		Filename:         filename,
+		RotationInterval: 24 * time.Hour,
+		AlignInterval:    true,
+	}
+	defer l.Close()
+
+	setFakeTime(time.Date(2025, 3, 1, 15, 4, 5, 0, time.UTC))
+	_, err := l.Write([]byte("afternoon\n"))
+	isNil(err, t)
+
+	// The first rotation waits for midnight, not a day after the first write.
+	setFakeTime(time.Date(2025, 3, 1, 23, 59, 59, 0, time.UTC))
+	_, err = l.Write([]byte("late\n"))
+	isNil(err, t)
+	fileCount(dir, 1, t)
+
+	setFakeTime(time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC))
+	_, err = l.Write([]byte("midnight\n"))
+	isNil(err, t)
+	existsWithContent(backupFileWithReason(dir, "time"), []byte("afternoon\nlate\n"), t)
+	existsWithContent(filename, []byte("midnight\n"), t)
+
+	setFakeTime(time.Date(2025, 3, 2, 15, 4, 5, 0, time.UTC))
+	_, err = l.Write([]byte("afternoon\n"))
+	isNil(err, t)
+	fileCount(dir, 2, t)
+}
+
+func TestMaxMillWorkPerRun(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	var names []string
+	for day := 1; day <= 7; day++ {
+		name := fmt.Sprintf("foobar-2025-01-%02dT00-00-00.000-size.log", day)
+		names = append(names, name)
+		isNil(os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644), t)
+	}
+
+	// Three backups to remove and four to compress, three at a time.
+	l := &Logger{Filename: logFile(dir), MaxBackups: 4, Compress: true, MaxMillWorkPerRun: 3}
+	defer l.Close()
+
+	more, err := l.millRunLimited(l.MaxMillWorkPerRun)
+	isNil(err, t)
+	equals(true, more, t)
+	for _, name := range names[:3] {
+		notExist(filepath.Join(dir, name), t)
+	}
+	for _, name := range names[3:] {
+		exists(filepath.Join(dir, name), t)
+	}
+
+	more, err = l.millRunLimited(l.MaxMillWorkPerRun)
+	isNil(err, t)
+	equals(true, more, t)
+	for _, name := range names[3:6] { // oldest first
+		exists(filepath.Join(dir, name+compressSuffix), t)
+	}
+	exists(filepath.Join(dir, names[6]), t)
+
+	more, err = l.millRunLimited(l.MaxMillWorkPerRun)
+	isNil(err, t)
+	equals(false, more, t)
+	exists(filepath.Join(dir, names[6]+compressSuffix), t)
+	fileCount(dir, 4, t)
+}
+
+func TestMaxMillWorkPerRunResignals(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	for day := 1; day <= 5; day++ {
+		name := fmt.Sprintf("foobar-2025-01-%02dT00-00-00.000-size.log", day)
+		isNil(os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644), t)
+	}
+
+	runs := make(chan []string, 10)
+	l := &Logger{
+		Filename:          logFile(dir),
+		MaxBackups:        1,
+		MaxMillWorkPerRun: 1,
+		OnCleanup: func(removed, compressed []string) {
+			runs <- removed
+		},
+	}
+	defer l.Close()
+
+	// A single signal works through the whole backlog, one file per run.
+	l.mu.Lock()
+	l.mill()
+	l.mu.Unlock()
+	for i := 0; i < 4; i++ {
+		select {
+		case removed := <-runs:
+			equals(1, len(removed), t)
+		case <-time.After(time.Second):
+			t.Fatalf("cleanup run %d didn't happen", i+1)
+		}
+	}
+	fileCount(dir, 1, t)
+}
+
+func TestOpenFDCount(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := t.TempDir()
+
+	l := &Logger{Filename: logFile(dir), MaxSize: 10, MaxBackups: 2, ReopenCacheTTL: time.Hour}
+	equals(0, l.OpenFDCount(), t)
+
+	for i := 0; i < 20; i++ {
+		_, err := l.Write([]byte("12345678"))
+		isNil(err, t)
+		if i%3 == 0 {
+			isNil(l.Rotate(), t)
+		}
+		equals(1, l.OpenFDCount(), t)
+	}
+
+	isNil(l.Close(), t)
+	equals(0, l.OpenFDCount(), t)
+
+	// A write after Close keeps its descriptor for ReopenCacheTTL.
+	_, err := l.Write([]byte("late"))
+	isNil(err, t)
+	equals(1, l.OpenFDCount(), t)
+	isNil(l.Close(), t)
+	equals(0, l.OpenFDCount(), t)
+}
+
+// recordingWriter records each Write call separately.
+type recordingWriter struct {
+	mu    sync.Mutex
+	calls []string
+	err   error
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.calls = append(w.calls, string(p))
+	if w.err != nil {
+		return 0, w.err
+	}
+	return len(p), nil
+}
+
+func TestSyslogWriter(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := t.TempDir()
+	filename := logFile(dir)
+
+	syslog := &recordingWriter{}
+	l := &Logger{Filename: filename, MaxSize: 20, SyslogWriter: syslog, NormalizeNewlines: true}
+	defer l.Close()
+
+	lines := []string{"first line\n", "second\r\n", "a rotating line\n"}
+	for _, line := range lines {
+		_, err := l.Write([]byte(line))
+		isNil(err, t)
+	}
+	_, err := l.Write(nil) // no message for an empty write
+	isNil(err, t)
+
+	equals([]string{"first line\n", "second\n", "a rotating line\n"}, syslog.calls, t)
+	existsWithContent(filename, []byte("a rotating line\n"), t)
+
+	// A failing syslog doesn't fail the write to the file.
+	syslog.err = errors.New("syslog down")
+	var reported error
+	l.OnRotationError = func(err error) { reported = err }
+	_, err = l.Write([]byte("still logged\n"))
+	isNil(err, t)
+	assert(errors.Is(reported, syslog.err), t, "expected the syslog error to be reported, got %v", reported)
+	existsWithContent(filename, []byte("still logged\n"), t)
+}
+
+func TestBackupsSkipsUnrelatedFiles(t *testing.T) {
+	dir := t.TempDir()
+	l := &Logger{Filename: filepath.Join(dir, "foobar.log")}
+
+	backups := []string{
+		"foobar-2025-01-02T00-00-00.000-time.log",
+		"foobar-2025-01-01T00-00-00.000-size.log.gz",
+	}
+	unrelated := []string{
+		"foobar.log",                                 // the active file
+		"other-2025-01-01T00-00-00.000-size.log",     // another logger's backup
+		"foobar-not-a-time-size.log",                 // unparseable timestamp
+		"foobar-2025-01-01T00-00-00.000-size.txt",    // wrong extension
+		"foobar-2025-01-03T00-00-00.000-size.log.md", // wrong suffix
+		"notes.txt",
+	}
+	for _, name := range append(backups, unrelated...) {
+		isNil(os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644), t)
+	}
+	isNil(os.Mkdir(filepath.Join(dir, "foobar-2025-01-04T00-00-00.000-size.log"), 0755), t)
+
+	got, err := l.Backups()
+	isNil(err, t)
+	equals(2, len(got), t)
+	equals(filepath.Join(dir, backups[0]), got[0].Path, t)
+	equals(false, got[0].Compressed, t)
+	equals(filepath.Join(dir, backups[1]), got[1].Path, t)
+	equals(true, got[1].Compressed, t)
+	equals(int64(1), got[1].Size, t)
+}
+
+func TestBackupsSorted(t *testing.T) {
+	dir := t.TempDir()
+	l := &Logger{Filename: filepath.Join(dir, "foobar.log")}
+
+	// Created out of order, so directory order doesn't help.
+	names := []string{
+		"foobar-2025-01-03T00-00-00.000-size.log",
+		"foobar-2025-01-01T00-00-00.000-size.log.gz",
+		"foobar-2025-01-04T00-00-00.000-time.log",
+		"foobar-2025-01-02T00-00-00.000-size.log",
+	}
+	for _, name := range names {
+		isNil(os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644), t)
+	}
+
+	paths := func(backups []BackupInfo) []string {
+		var out []string
+		for _, b := range backups {
+			out = append(out, filepath.Base(b.Path))
+		}
+		return out
+	}
+
+	newest, err := l.BackupsSorted(NewestFirst)
+	isNil(err, t)
+	equals([]string{names[2], names[0], names[3], names[1]}, paths(newest), t)
+
+	backups, err := l.Backups()
+	isNil(err, t)
+	equals(paths(newest), paths(backups), t)
+
+	oldest, err := l.BackupsSorted(OldestFirst)
+	isNil(err, t)
+	equals([]string{names[1], names[3], names[0], names[2]}, paths(oldest), t)
+
+	_, err = l.BackupsSorted(SortOrder(7))
+	notNil(err, t)
+}
+
+func TestStartBackgroundWorkers(t *testing.T) {
+	currentTime = fakeTime
+	origInterval := triggerPollInterval
+	triggerPollInterval = 10 * time.Millisecond
+	defer func() { triggerPollInterval = origInterval }()
+
+	dir := t.TempDir()
+	filename := logFile(dir)
+	trigger := filename + ".rotate"
+	isNil(os.WriteFile(filename, []byte("leftover\n"), 0644), t)
+	l := &Logger{Filename: filename, TriggerFile: trigger}
+	defer l.Close()
+
+	isNil(l.StartBackgroundWorkers(), t)
+	isNil(l.StartBackgroundWorkers(), t) // idempotent
+	l.mu.Lock()
+	equals(true, l.millCh != nil, t)
+	l.mu.Unlock()
+
+	// The trigger watcher runs before anything has been written.
+	isNil(os.WriteFile(trigger, nil, 0644), t)
+	time.Sleep(200 * time.Millisecond)
+	notExist(trigger, t)
+	existsWithContent(backupFileWithReason(dir, "size"), []byte("leftover\n"), t)
+
+	isNil(l.Close(), t)
+	notNil(l.StartBackgroundWorkers(), t)
+}
+
+func TestTransform(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := t.TempDir()
+	filename := logFile(dir)
+
+	token := regexp.MustCompile(`token=\w+`)
+	l := &Logger{
+		Filename: filename,
+		MaxSize:  40,
+		Transform: func(p []byte) []byte {
+			return token.ReplaceAll(p, []byte("token=[REDACTED]"))
+		},
+	}
+	defer l.Close()
+
+	// The redaction grows the line, but Write reports the caller's length.
+	line := []byte("login token=abc\n")
+	n, err := l.Write(line)
+	isNil(err, t)
+	equals(len(line), n, t)
+	existsWithContent(filename, []byte("login token=[REDACTED]\n"), t)
+
+	// Size accounting uses the transformed length (23+23 > 40).
+	n, err = l.Write(line)
+	isNil(err, t)
+	equals(len(line), n, t)
+	fileCount(dir, 2, t)
+	existsWithContent(filename, []byte("login token=[REDACTED]\n"), t)
+
+	// Shrinking works too, down to dropping the write entirely.
+	l.Transform = func(p []byte) []byte { return nil }
+	n, err = l.Write([]byte("dropped\n"))
+	isNil(err, t)
+	equals(8, n, t)
+	existsWithContent(filename, []byte("login token=[REDACTED]\n"), t)
+
+	// A write the transform makes too large fails as a whole.
+	l.Transform = func(p []byte) []byte { return bytes.Repeat(p, 50) }
+	n, err = l.Write([]byte("big\n"))
+	notNil(err, t)
+	equals(0, n, t)
+}
+
+func TestCompressSkipsGzipContent(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, err := zw.Write([]byte("already compressed\n"))
+	isNil(err, t)
+	isNil(zw.Close(), t)
+
+	gzipped := filepath.Join(dir, "foobar-2025-01-01T00-00-00.000-size.log")
+	plain := filepath.Join(dir, "foobar-2025-01-02T00-00-00.000-size.log")
+	isNil(os.WriteFile(gzipped, gz.Bytes(), 0644), t)
+	isNil(os.WriteFile(plain, []byte("plain\n"), 0644), t)
+
+	var compressed []string
+	l := &Logger{
+		Filename: logFile(dir),
+		Compress: true,
+		OnCleanup: func(_, c []string) {
+			compressed = c
+		},
+	}
+	defer l.Close()
+	isNil(l.millRunOnce(), t)
+
+	// The gzip content is renamed as is, not compressed a second time.
+	notExist(gzipped, t)
+	existsWithContent(gzipped+compressSuffix, gz.Bytes(), t)
+
+	notExist(plain, t)
+	rc, err := OpenBackup(plain + compressSuffix)
+	isNil(err, t)
+	content, err := io.ReadAll(rc)
+	isNil(err, t)
+	isNil(rc.Close(), t)
+	equals("plain\n", string(content), t)
+
+	equals(2, len(compressed), t)
+}
+
+func TestCompressMinAge(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	setFakeTime(time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC))
+
+	older := filepath.Join(dir, "foobar-2025-01-02T10-00-00.000-size.log")
+	recent := filepath.Join(dir, "foobar-2025-01-02T11-30-00.000-size.log")
+	isNil(os.WriteFile(older, []byte("older"), 0644), t)
+	isNil(os.WriteFile(recent, []byte("recent"), 0644), t)
+
+	l := &Logger{Filename: logFile(dir), Compress: true, CompressMinAge: time.Hour}
+	defer l.Close()
+
+	isNil(l.millRunOnce(), t)
+	exists(older+compressSuffix, t)
+	existsWithContent(recent, []byte("recent"), t)
+
+	// Still short of the threshold.
+	setFakeTime(time.Date(2025, 1, 2, 12, 29, 59, 0, time.UTC))
+	isNil(l.millRunOnce(), t)
+	existsWithContent(recent, []byte("recent"), t)
+
+	setFakeTime(time.Date(2025, 1, 2, 12, 30, 0, 0, time.UTC))
+	isNil(l.millRunOnce(), t)
+	notExist(recent, t)
+	exists(recent+compressSuffix, t)
+}
+
+func TestAuditFile(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := t.TempDir()
+	filename := logFile(dir)
+	// Inside the log directory, named so that it could pass for a backup.
+	auditFile := filepath.Join(dir, "foobar-audit.log")
+
+	old := filepath.Join(dir, "foobar-2000-01-01T00-00-00.000-size.log")
+	isNil(os.WriteFile(old, []byte("old"), 0644), t)
+
+	l := &Logger{Filename: filename, MaxSize: 10, MaxBackups: 1, AuditFile: auditFile}
+	defer l.Close()
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+	isNil(l.Rotate(), t)
+
+	// Wait for the cleanup run to remove the old backup.
+	var entries []auditEntry
+	for i := 0; i < 100; i++ {
+		entries = readAuditFile(auditFile, t)
+		if len(entries) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	equals(2, len(entries), t)
+
+	equals("rotate", entries[0].Event, t)
+	equals(backupFileWithReason(dir, "size"), entries[0].File, t)
+	equals("manual", entries[0].Reason, t)
+	equals("ok", entries[0].Outcome, t)
+	equals(true, fakeTime().Equal(entries[0].Time), t)
+
+	equals("remove", entries[1].Event, t)
+	equals(old, entries[1].File, t)
+	equals("ok", entries[1].Outcome, t)
+
+	// The audit file itself survives cleanup.
+	exists(auditFile, t)
+	backups, err := l.Backups()
+	isNil(err, t)
+	equals(1, len(backups), t)
+}
+
+func TestAuditFileError(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	auditFile := filepath.Join(dir, "audit.jsonl")
+	l := &Logger{Filename: logFile(dir), AuditFile: auditFile, AuditMaxSize: 1}
+	defer l.Close()
+
+	megabyte = 1 // an AuditMaxSize of one byte: every entry starts a new file
+	l.audit("remove", "a.log", "", errors.New("permission denied"))
+	l.audit("compress", "b.log", "", nil)
+
+	prev := readAuditFile(auditFile+".1", t)
+	equals(1, len(prev), t)
+	equals("error", prev[0].Outcome, t)
+	equals("permission denied", prev[0].Error, t)
+
+	cur := readAuditFile(auditFile, t)
+	equals(1, len(cur), t)
+	equals("compress", cur[0].Event, t)
+}
+
+// readAuditFile parses the JSON lines of an AuditFile.
+func readAuditFile(path string, t testing.TB) []auditEntry {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	isNilUp(err, t, 1)
+	var entries []auditEntry
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var e auditEntry
+		isNilUp(json.Unmarshal(line, &e), t, 1)
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestEvents(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := t.TempDir()
+	l := &Logger{Filename: logFile(dir), MaxSize: 10}
+	events := l.Events()
+	equals(events, l.Events(), t) // the same channel every time
+
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+	newFakeTime()
+	_, err = l.Write([]byte("foooooo!"))
+	isNil(err, t)
+
+	ev := <-events
+	equals(ReasonSize, ev.Reason, t)
+	equals(backupFileWithReason(dir, "size"), ev.BackupPath, t)
+	equals(int64(4), ev.Size, t)
+	assert(ev.Time.Equal(fakeTime()), t, "expected event time %v, got %v", fakeTime(), ev.Time)
+
+	isNil(l.Close(), t)
+	_, ok := <-events
+	assert(!ok, t, "expected the channel to be closed")
+	_, ok = <-l.Events()
+	assert(!ok, t, "expected a closed channel after Close")
+}
+
+func TestWebhookFunc(t *testing.T) {
+	currentTime = fakeTime
+	origDelay := webhookRetryDelay
+	webhookRetryDelay = time.Millisecond
+	defer func() { webhookRetryDelay = origDelay }()
+
+	dir := t.TempDir()
+	var mu sync.Mutex
+	attempts := 0
+	delivered := make(chan RotationEvent, 1)
+	l := &Logger{
+		Filename: logFile(dir),
+		WebhookFunc: func(ev RotationEvent) error {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts++
+			if attempts < 3 {
+				return errors.New("503 Service Unavailable")
+			}
+			delivered <- ev
+			return nil
+		},
+	}
+	defer l.Close()
+
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+	newFakeTime()
+	isNil(l.Rotate(), t)
+
+	select {
+	case ev := <-delivered:
+		equals(ReasonManual, ev.Reason, t)
+		equals(backupFileWithReason(dir, "size"), ev.BackupPath, t)
+		equals(int64(4), ev.Size, t)
+	case <-time.After(time.Second):
+		t.Fatal("webhook not delivered")
+	}
+	mu.Lock()
+	equals(3, attempts, t)
+	mu.Unlock()
+}
+
+func TestWebhookFuncGivesUp(t *testing.T) {
+	currentTime = fakeTime
+	origDelay := webhookRetryDelay
+	webhookRetryDelay = time.Millisecond
+	defer func() { webhookRetryDelay = origDelay }()
+
+	var attempts int32
+	reported := make(chan error, 1)
+	l := &Logger{
+		Filename:          logFile(t.TempDir()),
+		WebhookMaxRetries: 2,
+		WebhookFunc: func(RotationEvent) error {
+			atomic.AddInt32(&attempts, 1)
+			return errors.New("connection refused")
+		},
+		OnRotationError: func(err error) { reported <- err },
+	}
+	defer l.Close()
+
+	isNil(l.Rotate(), t)
+	select {
+	case err := <-reported:
+		assert(strings.Contains(err.Error(), "connection refused"), t, "unexpected error: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("webhook failure not reported")
+	}
+	equals(int32(3), atomic.LoadInt32(&attempts), t) // the first call and two retries
+}
+
+func TestEventsDropWhenFull(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	l := &Logger{Filename: logFile(dir)}
+	defer l.Close()
+	events := l.Events()
+
+	// Nobody receives, yet rotations never block.
+	for i := 0; i < eventsBufferSize+10; i++ {
+		newFakeTime()
+		isNil(l.Rotate(), t)
+	}
+	equals(eventsBufferSize, len(events), t)
+}
+
+func TestReopen(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	filename := logFile(dir)
+	l := &Logger{Filename: filename, RotationInterval: time.Hour}
+	defer l.Close()
+
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+	lastRotation := l.lastRotationTime
+
+	// An external rotator moves the file aside; writes follow it until Reopen.
+	moved := filepath.Join(dir, "foobar.log.1")
+	isNil(os.Rename(filename, moved), t)
+	_, err = l.Write([]byte("moo!"))
+	isNil(err, t)
+	existsWithContent(moved, []byte("boo!moo!"), t)
+
+	isNil(l.Reopen(), t)
+	_, err = l.Write([]byte("foo!"))
+	isNil(err, t)
+	existsWithContent(filename, []byte("foo!"), t)
+	existsWithContent(moved, []byte("boo!moo!"), t)
+	fileCount(dir, 2, t) // no backup of our own
+	equals(lastRotation, l.lastRotationTime, t)
+
+	// After copytruncate, the size is taken from the file again.
+	isNil(os.Truncate(filename, 0), t)
+	isNil(l.Reopen(), t)
+	equals(int64(0), l.size, t)
+
+	isNil(l.Close(), t)
+	equals(ErrClosed, l.Reopen(), t)
+}
+
+func TestRotateWithInfo(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	filename := logFile(dir)
+	l := &Logger{Filename: filename, Compress: true}
+	defer l.Close()
+
+	// Nothing written yet, so there is nothing to move aside.
+	path, err := l.RotateWithInfo()
+	isNil(err, t)
+	equals("", path, t)
+
+	_, err = l.Write([]byte("boo!"))
+	isNil(err, t)
+	newFakeTime()
+	path, err = l.RotateWithInfo()
+	isNil(err, t)
+	// The uncompressed name, even though the backup is compressed afterwards.
+	equals(backupFileWithReason(dir, "size"), path, t)
+
+	isNil(l.Close(), t) // waits for the compression
+	exists(path+compressSuffix, t)
+	notExist(path, t)
+
+	_, err = l.RotateWithInfo()
+	equals(ErrClosed, err, t)
+}
+
+func TestWriteAfterRotateGoesToNewFile(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := t.TempDir()
+	filename := logFile(dir)
+	// Large enough that only Rotate rotates.
+	l := &Logger{Filename: filename, MaxSize: 1 << 30}
+	defer l.Close()
+
+	// Writers label each line with the phase they observed before writing it:
+	// 0 before Rotate has returned, 1 after.
+	var phase int32
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	var postLines int64
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				p := atomic.LoadInt32(&phase)
+				_, err := l.Write([]byte(fmt.Sprintf("g%d i%d phase%d\n", g, i, p)))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if p == 1 {
+					atomic.AddInt64(&postLines, 1)
+				}
+			}
+		}(g)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	isNil(l.Rotate(), t)
+	atomic.StoreInt32(&phase, 1)
+	time.Sleep(20 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	backup, err := os.ReadFile(backupFileWithReason(dir, "size"))
+	isNil(err, t)
+	assert(!bytes.Contains(backup, []byte("phase1")), t, "a write made after Rotate returned went to the old file")
+
+	current, err := os.ReadFile(filename)
+	isNil(err, t)
+	equals(int(atomic.LoadInt64(&postLines)), bytes.Count(current, []byte("phase1\n")), t)
+	assert(postLines > 0, t, "no writes after Rotate")
+}
+
+func TestFractionalSecondsElement(t *testing.T) {
+	tests := []struct {
+		layout string
+		want   string
+	}{
+		{"2006-01-02T15-04-05.000", ".000"},
+		{"2006-01-02T15-04-05,999999", ",999999"},
+		{"2006-01-02T15-04-05", ""},
+		{"2006.01.02-15.04.05", ""},
+		{"2006.01.02-15.04.05.000000", ".000000"},
+	}
+	for _, test := range tests {
+		equals(test.want, fractionalSecondsElement(test.layout), t)
+	}
+}
+
+func TestPreviousBackupTimeFormats(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "foobar.log")
+	oldFormat := "2006-01-02-15-04-05"
+
+	// Backups from a run with the old format, and one with the current format.
+	names := []string{
+		"foobar-2025-01-01-00-00-00-size.log",
+		"foobar-2025-01-02-00-00-00-time.log.gz",
+		"foobar-2025-01-03T00-00-00.000-size.log",
+	}
+	for _, name := range names {
+		isNil(os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644), t)
+	}
+
+	// Without the old format, its backups are not recognized.
+	l := &Logger{Filename: filename, BackupTimeFormat: backupTimeFormat}
+	files, err := l.oldLogFiles()
+	isNil(err, t)
+	equals(1, len(files), t)
+
+	l = &Logger{
+		Filename:                  filename,
+		BackupTimeFormat:          backupTimeFormat,
+		PreviousBackupTimeFormats: []string{"2006.01.02", oldFormat},
+		MaxBackups:                1,
+	}
+	files, err = l.oldLogFiles()
+	isNil(err, t)
+	equals(3, len(files), t)
+	equals(time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), files[1].timestamp, t)
+
+	// They are pruned along with the rest.
+	isNil(l.ApplyRetention(), t)
+	fileCount(dir, 1, t)
+	exists(filepath.Join(dir, names[2]), t)
+}
+
+func TestBackupTimeFormatPrecisionRoundTrip(t *testing.T) {
+	for _, format := range []string{
+		"2006-01-02T15-04-05.000000",
+		"2006.01.02-15.04.05.999999999",
+		"2006.01.02T15-04-05", // digits after a dot, but no fractional seconds
+	} {
+		t.Run(format, func(t *testing.T) {
+			megabyte = 1
+			dir := t.TempDir()
+			l := &Logger{Filename: logFile(dir), MaxSize: 10, BackupTimeFormat: format}
+			isNil(l.ValidateBackupTimeFormat(), t)
+
+			// Rotations a few seconds and odd nanoseconds apart.
+			start := time.Date(2025, 1, 1, 10, 0, 0, 123456789, time.UTC)
+			currentTime = func() time.Time { return fakeCurrentTime }
+			for i := 0; i < 4; i++ {
+				setFakeTime(start.Add(time.Duration(i)*time.Second + time.Duration(i)*7*time.Microsecond))
+				_, err := l.Write([]byte("0123456789"))
+				isNil(err, t)
+			}
+			isNil(l.Close(), t)
+			equals(format, l.BackupTimeFormat, t) // valid, so kept
+
+			// Every backup is recognized, with its timestamp at the format's precision.
+			files, err := l.oldLogFiles()
+			isNil(err, t)
+			equals(3, len(files), t)
+			for _, f := range files {
+				equals(f.Name(), filepath.Base(backupName(l.Filename, false, "size", f.timestamp, format)), t)
+			}
+
+			// MaxBackups then MaxAge prune them.
+			l.MaxBackups, l.MaxAge = 2, 1
+			isNil(l.ApplyRetention(), t)
+			fileCount(dir, 3, t)
+			setFakeTime(start.Add(48 * time.Hour))
+			isNil(l.ApplyRetention(), t)
+			fileCount(dir, 1, t)
+		})
+	}
+}
+
+func TestSyncBackupBeforeHook(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+
+	var events []string
+	origSyncPath := syncPath
+	syncPath = func(path string) error {
+		events = append(events, "sync "+filepath.Base(path))
+		return origSyncPath(path)
+	}
+	defer func() { syncPath = origSyncPath }()
+
+	l := &Logger{
+		Filename:             logFile(dir),
+		SyncBackupBeforeHook: true,
+		OnRotate: func(_ RotationReason, backupPath string) {
+			if backupPath == "" {
+				events = append(events, "hook")
+				return
+			}
+			events = append(events, "hook "+filepath.Base(backupPath))
+		},
+	}
+	defer l.Close()
+
+	// No backup, nothing to sync.
+	isNil(l.Rotate(), t)
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+	isNil(l.Rotate(), t)
+
+	backup := filepath.Base(backupFileWithReason(dir, "size"))
+	equals([]string{"hook", "sync " + backup, "hook " + backup}, events, t)
+
+	// A failed sync is reported, and the hook still runs.
+	syncPath = func(string) error { return errors.New("sync failed") }
+	var reported error
+	l.OnRotationError = func(err error) { reported = err }
+	isNil(l.Rotate(), t)
+	notNil(reported, t)
+	equals(4, len(events), t)
+}
+
+func TestPreserveModTime(t *testing.T) {
+	currentTime = fakeTime
+	for _, preserve := range []bool{false, true} {
+		dir := t.TempDir()
+		backup := filepath.Join(dir, "foobar-2025-01-01T00-00-00.000-size.log")
+		isNil(os.WriteFile(backup, []byte("content"), 0644), t)
+		mtime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		isNil(os.Chtimes(backup, mtime, mtime), t)
+
+		l := &Logger{Filename: logFile(dir), Compress: true, PreserveModTime: preserve}
+		isNil(l.millRunOnce(), t)
+		isNil(l.Close(), t)
+
+		info, err := os.Stat(backup + compressSuffix)
+		isNil(err, t)
+		equals(preserve, info.ModTime().Equal(mtime), t)
+	}
+}
+
+func TestOnRotatePanicRecovered(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+
+	var reported error
+	l := &Logger{
+		Filename:        logFile(dir),
+		OnRotate:        func(RotationReason, string) { panic("boom") },
+		OnRotationError: func(err error) { reported = err },
+	}
+	defer l.Close()
+
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+	isNil(l.Rotate(), t)
+	notNil(reported, t)
+	assert(strings.Contains(reported.Error(), "boom"), t, "unexpected error %v", reported)
+
+	// The Logger is still usable.
+	_, err = l.Write([]byte("after\n"))
+	isNil(err, t)
+	existsWithContent(logFile(dir), []byte("after\n"), t)
+}
+
+func TestOnRotateMayCallBackIntoLogger(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+
+	var l *Logger
+	var calls []RotationReason
+	l = &Logger{
+		Filename: logFile(dir),
+		OnRotate: func(reason RotationReason, backupPath string) {
+			calls = append(calls, reason)
+			_, err := l.Write([]byte("rotated\n"))
+			isNil(err, t)
+			if len(calls) == 1 {
+				// Rotating again from the hook doesn't deadlock; the nested call
+				// to OnRotate follows once this one returns.
+				newFakeTime()
+				isNil(l.Rotate(), t)
+				equals(1, len(calls), t)
+			}
+		},
+	}
+	defer l.Close()
+
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+	isNil(l.Rotate(), t)
+	equals([]RotationReason{ReasonManual, ReasonManual}, calls, t)
+	existsWithContent(logFile(dir), []byte("rotated\n"), t)
+}
+
+func TestFlushInterval(t *testing.T) {
+	currentTime = fakeTime
+	origInterval := flushCheckInterval
+	flushCheckInterval = 10 * time.Millisecond
+	defer func() { flushCheckInterval = origInterval }()
+
+	dir := t.TempDir()
+	filename := logFile(dir)
+	l := &Logger{Filename: filename, BufferSize: 1024, FlushInterval: time.Minute}
+	defer l.Close()
+
+	b := []byte("buffered\n")
+	n, err := l.Write(b)
+	isNil(err, t)
+	equals(len(b), n, t)
+
+	// Not idle for long enough yet: the data stays in the buffer.
+	time.Sleep(50 * time.Millisecond)
+	existsWithContent(filename, []byte{}, t)
+
+	l.mu.Lock()
+	setFakeTime(fakeCurrentTime.Add(time.Minute))
+	l.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	existsWithContent(filename, b, t)
+}
+
+func TestBufferSizeFlushesBeforeRotationAndClose(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := t.TempDir()
+	filename := logFile(dir)
+	l := &Logger{Filename: filename, MaxSize: 10, BufferSize: 1024}
+
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+	existsWithContent(filename, []byte{}, t)
+
+	// Buffered bytes count towards MaxSize, and are flushed into the backup.
+	_, err = l.Write([]byte("foooooo!"))
+	isNil(err, t)
+	existsWithContent(backupFileWithReason(dir, "size"), []byte("boo!"), t)
+	existsWithContent(filename, []byte{}, t)
+
+	isNil(l.Close(), t)
+	existsWithContent(filename, []byte("foooooo!"), t)
+}
+
+func TestBufferSizeFlush(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := t.TempDir()
+	filename := logFile(dir)
+	l := &Logger{Filename: filename, MaxSize: 100, BufferSize: 64}
+
+	isNil(l.Flush(), t) // nothing written yet
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+	existsWithContent(filename, []byte{}, t)
+	isNil(l.Flush(), t)
+	existsWithContent(filename, []byte("boo!"), t)
+
+	// Many writes through a small buffer and several rotations lose nothing.
+	var returned int
+	for i := 0; i < 100; i++ {
+		newFakeTime()
+		n, err := l.Write([]byte(fmt.Sprintf("line %d\n", i)))
+		isNil(err, t)
+		returned += n
+	}
+	isNil(l.Close(), t)
+	equals(ErrClosed, l.Flush(), t)
+
+	var onDisk int64
+	entries, err := os.ReadDir(dir)
+	isNil(err, t)
+	assert(len(entries) > 3, t, "expected several rotations, got %d files", len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		isNil(err, t)
+		onDisk += info.Size()
+	}
+	equals(int64(returned+len("boo!")), onDisk, t)
+}
+
+func TestMaxWrites(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	filename := logFile(dir)
+	var reasons []RotationReason
+	l := &Logger{
+		Filename:  filename,
+		MaxWrites: 3,
+		OnRotate:  func(reason RotationReason, _ string) { reasons = append(reasons, reason) },
+	}
+	defer l.Close()
+
+	for _, w := range []string{"a\n", "b\n"} {
+		_, err := l.Write([]byte(w))
+		isNil(err, t)
+	}
+	fileCount(dir, 1, t)
+
+	// The third write goes into the file, which is then rotated.
+	_, err := l.Write([]byte("c\n"))
+	isNil(err, t)
+	existsWithContent(backupFileWithReason(dir, "count"), []byte("a\nb\nc\n"), t)
+	existsWithContent(filename, []byte{}, t)
+	equals([]RotationReason{ReasonCount}, reasons, t)
+	equals("count", ReasonCount.String(), t)
+
+	// Other rotations restart the count.
+	_, err = l.Write([]byte("d\n"))
+	isNil(err, t)
+	newFakeTime()
+	isNil(l.Rotate(), t)
+	for _, w := range []string{"e\n", "f\n"} {
+		_, err := l.Write([]byte(w))
+		isNil(err, t)
+	}
+	existsWithContent(filename, []byte("e\nf\n"), t)
+}
+
+func TestMaxWritesRotateTo(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	filename := logFile(dir)
+	l := &Logger{Filename: filename, MaxWrites: 3}
+	defer l.Close()
+
+	for _, w := range []string{"a\n", "b\n"} {
+		_, err := l.Write([]byte(w))
+		isNil(err, t)
+	}
+	dest := filepath.Join(t.TempDir(), "harvested.log")
+	isNil(l.RotateTo(dest), t)
+	existsWithContent(dest, []byte("a\nb\n"), t)
+
+	// The new file gets its full MaxWrites.
+	for _, w := range []string{"c\n", "d\n"} {
+		_, err := l.Write([]byte(w))
+		isNil(err, t)
+	}
+	existsWithContent(filename, []byte("c\nd\n"), t)
+	fileCount(dir, 1, t)
+
+	_, err := l.Write([]byte("e\n"))
+	isNil(err, t)
+	existsWithContent(backupFileWithReason(dir, "count"), []byte("c\nd\ne\n"), t)
+}
+
+func TestSyncDoesNotRotate(t *testing.T) {
+	currentTime = fakeTime
+	megabyte = 1
+	dir := t.TempDir()
+	filename := logFile(dir)
+	l := &Logger{Filename: filename, MaxSize: 4}
+	defer l.Close()
+
+	// The file is full, so the next write would rotate; Sync must not.
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+	isNil(l.Sync(), t)
+	existsWithContent(filename, []byte("boo!"), t)
+	fileCount(dir, 1, t)
+}
+
+func TestErrClosed(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	l := &Logger{Filename: logFile(dir), BufferSize: 1024}
+
+	isNil(l.Sync(), t) // nothing written yet
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+	existsWithContent(logFile(dir), []byte{}, t)
+	isNil(l.Sync(), t)
+	existsWithContent(logFile(dir), []byte("boo!"), t)
+
+	isNil(l.Close(), t)
+	assert(errors.Is(l.Rotate(), ErrClosed), t, "Rotate: expected ErrClosed")
+	assert(errors.Is(l.Sync(), ErrClosed), t, "Sync: expected ErrClosed")
+	assert(errors.Is(l.RotateTo(filepath.Join(dir, "harvest.log")), ErrClosed), t, "RotateTo: expected ErrClosed")
+	assert(errors.Is(l.StartBackgroundWorkers(), ErrClosed), t, "StartBackgroundWorkers: expected ErrClosed")
+	_, err = l.Tail(context.Background(), 0)
+	assert(errors.Is(err, ErrClosed), t, "Tail: expected ErrClosed")
+	isNil(l.Close(), t) // closing twice is fine
+}
+
+// manualClock is a Clock that only moves when told to.
+type manualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestClock(t *testing.T) {
+	// The package clock is far from the injected one, so using it would show.
+	currentTime = fakeTime
+	dir := t.TempDir()
+	filename := logFile(dir)
+
+	clock := &manualClock{now: time.Date(2030, 6, 1, 12, 0, 0, 0, time.UTC)}
+	old := filepath.Join(dir, "foobar-2030-05-01T00-00-00.000-size.log")
+	isNil(os.WriteFile(old, []byte("old"), 0644), t)
+
+	l := &Logger{Filename: filename, Clock: clock, RotationInterval: time.Hour, MaxAge: 7}
+	defer l.Close()
+
+	_, err := l.Write([]byte("first\n"))
+	isNil(err, t)
+
+	clock.Advance(time.Hour)
+	_, err = l.Write([]byte("second\n"))
+	isNil(err, t)
+	existsWithContent(filepath.Join(dir, "foobar-2030-06-01T13-00-00.000-time.log"), []byte("first\n"), t)
+	existsWithContent(filename, []byte("second\n"), t)
+
+	// MaxAge is measured against the injected clock too.
+	isNil(l.ApplyRetention(), t)
+	notExist(old, t)
+	fileCount(dir, 2, t)
+}
+
+func TestThinningPolicy(t *testing.T) {
+	dir := t.TempDir()
+	clock := &manualClock{now: time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)}
+	l := &Logger{
+		Filename:       filepath.Join(dir, "foobar.log"),
+		Clock:          clock,
+		ThinningPolicy: ThinningPolicy{KeepAllDays: 2},
+	}
+
+	kept := []string{
+		// Within the last two days: all kept.
+		"foobar-2025-01-10T06-00-00.000-size.log",
+		"foobar-2025-01-09T18-00-00.000-size.log",
+		"foobar-2025-01-09T06-00-00.000-size.log",
+		"foobar-2025-01-08T13-00-00.000-size.log",
+		// Older: the newest of each day.
+		"foobar-2025-01-08T11-00-00.000-time.log.gz",
+		"foobar-2025-01-07T23-00-00.000-size.log",
+		"foobar-2025-01-05T08-00-00.000-size.log",
+	}
+	removed := []string{
+		"foobar-2025-01-08T01-00-00.000-size.log.gz",
+		"foobar-2025-01-07T12-00-00.000-size.log",
+		"foobar-2025-01-07T00-00-00.000-size.log",
+	}
+	for _, name := range append(kept, removed...) {
+		isNil(os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644), t)
+	}
+
+	isNil(l.millRunOnce(), t)
+	for _, name := range kept {
+		exists(filepath.Join(dir, name), t)
+	}
+	for _, name := range removed {
+		notExist(filepath.Join(dir, name), t)
+	}
+
+	// Two days later, the 8th and 9th are thinned too.
+	clock.now = clock.now.Add(48 * time.Hour)
+	isNil(l.millRunOnce(), t)
+	notExist(filepath.Join(dir, kept[2]), t)
+	notExist(filepath.Join(dir, kept[4]), t)
+	fileCount(dir, len(kept)-2, t)
+}
+
+func TestMaxTotalSize(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	sizes := []int{30, 20, 20, 40} // oldest to newest
+	var names []string
+	for i, size := range sizes {
+		name := fmt.Sprintf("foobar-2025-01-%02dT00-00-00.000-size.log", i+1)
+		if i == 0 {
+			name += compressSuffix // compressed backups count with their size on disk
+		}
+		names = append(names, name)
+		isNil(os.WriteFile(filepath.Join(dir, name), bytes.Repeat([]byte("x"), size), 0644), t)
+	}
+
+	l := &Logger{Filename: logFile(dir), MaxTotalSize: 85}
+	defer l.Close()
+	isNil(l.millRunOnce(), t)
+
+	// 40+20+20 fit; adding the oldest 30 wouldn't.
+	notExist(filepath.Join(dir, names[0]), t)
+	for _, name := range names[1:] {
+		exists(filepath.Join(dir, name), t)
+	}
+
+	// MaxBackups applies first; the cap then sees only the two newest.
+	l.MaxBackups = 2
+	l.MaxTotalSize = 59
+	isNil(l.millRunOnce(), t)
+	fileCount(dir, 1, t)
+	exists(filepath.Join(dir, names[3]), t)
+
+	// The newest backup is kept even when it exceeds the cap by itself.
+	l.MaxTotalSize = 10
+	isNil(l.millRunOnce(), t)
+	exists(filepath.Join(dir, names[3]), t)
+}
+
+func TestRenameOrCopyAcrossDevices(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	otherDir := t.TempDir() // stands in for another filesystem
+	filename := logFile(dir)
+
+	origRename := osRename
+	osRename = func(oldpath, newpath string) error {
+		if filepath.Dir(oldpath) != filepath.Dir(newpath) {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+		}
+		return origRename(oldpath, newpath)
+	}
+	defer func() { osRename = origRename }()
+
+	isNil(os.WriteFile(filename, nil, 0600), t)
+	l := &Logger{Filename: filename}
+	defer l.Close()
+	_, err := l.Write([]byte("boo!"))
+	isNil(err, t)
+
+	dest := filepath.Join(otherDir, "harvest.log")
+	isNil(l.RotateTo(dest), t)
+	existsWithContent(dest, []byte("boo!"), t)
+	info, err := os.Stat(dest)
+	isNil(err, t)
+	equals(os.FileMode(0600), info.Mode().Perm(), t)
+	existsWithContent(filename, []byte{}, t)
+
+	// Errors other than EXDEV are returned as they are.
+	osRename = func(string, string) error { return os.ErrPermission }
+	err = renameOrCopy(filename, filepath.Join(otherDir, "other.log"))
+	assert(errors.Is(err, os.ErrPermission), t, "expected ErrPermission, got %v", err)
+	notExist(filepath.Join(otherDir, "other.log"), t)
+	exists(filename, t)
+}
+
+func TestOmitReasonInName(t *testing.T) {
+	currentTime = fakeTime
+	dir := t.TempDir()
+	filename := logFile(dir)
+	l := &Logger{Filename: filename, OmitReasonInName: true, MaxBackups: 1}
+	defer l.Close()
+
+	_, err := l.Write([]byte("first\n"))
+	isNil(err, t)
+	isNil(l.Rotate(), t)
+	first := filepath.Join(dir, "foobar-"+fakeTime().UTC().Format(backupTimeFormat)+".log")
+	existsWithContent(first, []byte("first\n"), t)
+
+	// Reasonless names are parsed back for cleanup, next to reasoned ones.
+	newFakeTime()
+	_, err = l.Write([]byte("second\n"))
+	isNil(err, t)
+	isNil(l.Rotate(), t)
+	second := filepath.Join(dir, "foobar-"+fakeTime().UTC().Format(backupTimeFormat)+".log")
+	existsWithContent(second, []byte("second\n"), t)
+
+	files, err := l.oldLogFiles()
+	isNil(err, t)
+	equals(2, len(files), t)
+	equals(fakeTime().UTC().Truncate(time.Millisecond), files[0].timestamp, t)
+
+	isNil(l.ApplyRetention(), t)
+	notExist(first, t)
+	exists(second, t)
+	fileCount(dir, 2, t)
+}